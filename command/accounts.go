@@ -0,0 +1,85 @@
+package command
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+)
+
+// account describes an IAM role which is assumed to reach an AWS account.
+type account struct {
+	RoleArn     string `json:"roleArn"`
+	ExternalID  string `json:"externalId"`
+	SessionName string `json:"sessionName"`
+}
+
+// Read accounts from --role-arn and --accounts-file. External ID and
+// session name from the command line are used when an account does not
+// specify its own.
+func loadAccounts(options AwsOptions) ([]*account, error) {
+	var accounts []*account
+	if options.AccountsFile != "" {
+		content, err := ioutil.ReadFile(options.AccountsFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(content, &accounts); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, role := range options.RoleArns {
+		accounts = append(accounts, &account{RoleArn: role})
+	}
+
+	for _, a := range accounts {
+		if a.ExternalID == "" {
+			a.ExternalID = options.ExternalID
+		}
+		if a.SessionName == "" {
+			a.SessionName = options.SessionName
+		}
+	}
+	return accounts, nil
+}
+
+// Create one manager per account. If no roles are configured, a single
+// manager is created using the credentials from the default session.
+func newManagers(opts manager.Opts) ([]*manager.Manager, error) {
+	sess, err := newSession()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create new aws session")
+	}
+
+	accounts, err := loadAccounts(Command.AwsOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load accounts")
+	}
+	if len(accounts) == 0 {
+		return []*manager.Manager{manager.NewManager(sess, Command.AwsOpts.Region, opts)}, nil
+	}
+
+	var managers []*manager.Manager
+	for _, a := range accounts {
+		role, err := arn.Parse(a.RoleArn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid role arn: %s", a.RoleArn)
+		}
+		creds := stscreds.NewCredentials(sess, a.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+			if a.ExternalID != "" {
+				p.ExternalID = aws.String(a.ExternalID)
+			}
+			p.RoleSessionName = a.SessionName
+		})
+
+		o := opts
+		o.AccountID = role.AccountID
+		managers = append(managers, manager.NewManager(sess.Copy(&aws.Config{Credentials: creds}), Command.AwsOpts.Region, o))
+	}
+	return managers, nil
+}
@@ -0,0 +1,125 @@
+package command_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAccounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	accountsFile := filepath.Join(dir, "accounts.json")
+	content := `[
+  {"roleArn": "arn:aws:iam::333333333333:role/ssm", "externalId": "file-id"},
+  {"roleArn": "arn:aws:iam::444444444444:role/ssm", "sessionName": "file-session"}
+]`
+	if err := ioutil.WriteFile(accountsFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var options command.AwsOptions
+	_, err = flags.ParseArgs(&options, []string{
+		"--role-arn", "arn:aws:iam::111111111111:role/ssm",
+		"--role-arn", "arn:aws:iam::222222222222:role/ssm",
+		"--accounts-file", accountsFile,
+		"--external-id", "flag-id",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"arn:aws:iam::111111111111:role/ssm", "arn:aws:iam::222222222222:role/ssm"}, options.RoleArns)
+
+	accounts, err := command.LoadAccounts(options)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Accounts from the file come first, and use the command line options as defaults.
+	var roles, externalIDs, sessionNames []string
+	for _, a := range accounts {
+		roles = append(roles, a.RoleArn)
+		externalIDs = append(externalIDs, a.ExternalID)
+		sessionNames = append(sessionNames, a.SessionName)
+	}
+	assert.Equal(t, []string{
+		"arn:aws:iam::333333333333:role/ssm",
+		"arn:aws:iam::444444444444:role/ssm",
+		"arn:aws:iam::111111111111:role/ssm",
+		"arn:aws:iam::222222222222:role/ssm",
+	}, roles)
+	assert.Equal(t, []string{"file-id", "flag-id", "flag-id", "flag-id"}, externalIDs)
+	assert.Equal(t, []string{"ssm-sh", "file-session", "ssm-sh", "ssm-sh"}, sessionNames)
+
+	t.Run("Invalid accounts file", func(t *testing.T) {
+		invalid := filepath.Join(dir, "invalid.json")
+		if err := ioutil.WriteFile(invalid, []byte(`{"roleArn": "arn:aws:iam::333333333333:role/ssm"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := command.LoadAccounts(command.AwsOptions{AccountsFile: invalid})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestNewManagers(t *testing.T) {
+	defer func(options command.AwsOptions) {
+		command.Command.AwsOpts = options
+	}(command.Command.AwsOpts)
+
+	accountIDs := func(managers []*manager.Manager) []string {
+		var ids []string
+		for _, m := range managers {
+			ids = append(ids, m.AccountID())
+		}
+		return ids
+	}
+
+	t.Run("Default account", func(t *testing.T) {
+		command.Command.AwsOpts = command.AwsOptions{Region: "eu-west-1"}
+		managers, err := command.NewManagers(manager.Opts{})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{""}, accountIDs(managers))
+
+		m, err := command.NewDocumentManager()
+		assert.Nil(t, err)
+		assert.Equal(t, "", m.AccountID())
+	})
+
+	t.Run("One manager per role", func(t *testing.T) {
+		command.Command.AwsOpts = command.AwsOptions{
+			Region:   "eu-west-1",
+			RoleArns: []string{"arn:aws:iam::111111111111:role/ssm", "arn:aws:iam::222222222222:role/ssm"},
+		}
+		managers, err := command.NewManagers(manager.Opts{})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"111111111111", "222222222222"}, accountIDs(managers))
+
+		_, err = command.NewDocumentManager()
+		assert.EqualError(t, err, "document commands work in a single account, but 2 roles are set")
+	})
+
+	t.Run("Document commands use a single role", func(t *testing.T) {
+		command.Command.AwsOpts = command.AwsOptions{
+			Region:   "eu-west-1",
+			RoleArns: []string{"arn:aws:iam::111111111111:role/ssm"},
+		}
+		m, err := command.NewDocumentManager()
+		assert.Nil(t, err)
+		assert.Equal(t, "111111111111", m.AccountID())
+	})
+
+	t.Run("Invalid role", func(t *testing.T) {
+		command.Command.AwsOpts = command.AwsOptions{Region: "eu-west-1", RoleArns: []string{"ssm"}}
+		_, err := command.NewManagers(manager.Opts{})
+		assert.EqualError(t, err, "invalid role arn: ssm: arn: invalid prefix")
+	})
+}
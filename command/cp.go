@@ -0,0 +1,454 @@
+package command
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Number of base64 characters sent per command when uploading inline.
+	uploadChunkSize = 48 * 1024
+	// Files larger than this are staged in S3 instead of being sent inline.
+	inlineUploadLimit = 256 * 1024
+	// Marks the start of a downloaded file in the command output.
+	downloadHeader = "ssm-sh-download"
+)
+
+var (
+	ownerPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+(:[A-Za-z0-9._-]+)?$`)
+	modePattern  = regexp.MustCompile(`^[0-7]{3,4}$`)
+)
+
+type CpCommand struct {
+	Owner      string     `long:"owner" description:"Owner (user[:group]) of uploaded files. Defaults to the owner of the file being replaced."`
+	Mode       string     `long:"mode" description:"Mode (e.g. 0644) of uploaded files. Defaults to the mode of the file being replaced."`
+	Timeout    int        `short:"i" long:"timeout" description:"Seconds to wait for each command before timing out." default:"60"`
+	SSMOpts    SSMOptions `group:"SSM options"`
+	TargetOpts TargetOptions
+}
+
+func (command *CpCommand) Usage() string {
+	return "[cp-OPTIONS] source destination"
+}
+
+func (command *CpCommand) Execute(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected a source and a destination")
+	}
+	srcTarget, src, srcRemote := ParseRemotePath(args[0])
+	dstTarget, dst, dstRemote := ParseRemotePath(args[1])
+
+	switch {
+	case !srcRemote && dstRemote:
+		if dst == "" {
+			return errors.New("missing remote path")
+		}
+		if dstTarget != "" {
+			command.TargetOpts.Targets = append(command.TargetOpts.Targets, dstTarget)
+		}
+		return command.upload(src, dst)
+	case srcRemote && !dstRemote:
+		if src == "" {
+			return errors.New("missing remote path")
+		}
+		if srcTarget != "" {
+			command.TargetOpts.Targets = append(command.TargetOpts.Targets, srcTarget)
+		}
+		return command.download(src, dst)
+	}
+	return errors.New("expected one local path and one remote ([target]:path) path")
+}
+
+func (command *CpCommand) upload(src, dst string) error {
+	opts := UploadOptions{Owner: command.Owner, Mode: command.Mode}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to read file")
+	}
+	name := filepath.Base(src)
+
+	ssmOpts, err := command.SSMOpts.Parse()
+	if err != nil {
+		return err
+	}
+	managers, err := newManagers(*ssmOpts)
+	if err != nil {
+		return err
+	}
+	f, err := setTargets(managers, command.TargetOpts, "Linux")
+	if err != nil {
+		return errors.Wrap(err, "failed to set targets")
+	}
+
+	var scripts []string
+	if len(data) <= inlineUploadLimit {
+		scripts = UploadScripts(data, name, dst, opts)
+	} else {
+		if ssmOpts.S3Bucket == "" {
+			return errors.Errorf("%s is larger than %d bytes, use --s3-bucket to upload it through s3", src, inlineUploadLimit)
+		}
+		staged, err := managers[0].StageObject(name, data, time.Hour)
+		if err != nil {
+			return err
+		}
+		defer managers[0].RemoveStagedObject(staged)
+		scripts = []string{StagedUploadScript(staged.URL, data, name, dst, opts)}
+	}
+	fmt.Printf("Uploading %s (%d bytes) to %s\n", src, len(data), dst)
+
+	// Remove the partial uploads from the remaining targets if the upload is aborted
+	// (targets where a script failed remove it themselves). collectOutput has
+	// cancelled the script in flight by then, so it does not recreate the file.
+	abort := interruptHandler()
+	cleanup := func(err error) error {
+		fmt.Printf("Removing partial uploads after error: %s\n", err)
+		script := UploadCleanupScript(data, name, dst)
+		if _, err := collectOutput(f, "AWS-RunShellScript", map[string]string{"commands": script}, command.Timeout, abort, func(*manager.CommandOutput) error {
+			return nil
+		}); err != nil {
+			fmt.Printf("Failed to remove partial uploads: %s\n", err)
+		}
+		return err
+	}
+
+	// Send all but the last chunk quietly, and drop targets where a chunk failed.
+	var failed int
+	for _, script := range scripts[:len(scripts)-1] {
+		errored := make(map[string]bool)
+		_, err := collectOutput(f, "AWS-RunShellScript", map[string]string{"commands": script}, command.Timeout, abort, func(output *manager.CommandOutput) error {
+			if output.Status == "Success" && output.Error == nil {
+				return nil
+			}
+			errored[output.InstanceID] = true
+			return PrintCommandOutput(os.Stdout, output)
+		})
+		if err != nil {
+			return cleanup(err)
+		}
+		n, err := f.Remove(func(instance *manager.Instance) bool {
+			return errored[instance.ID()]
+		})
+		if err != nil {
+			return err
+		}
+		failed += n
+		if len(f.Targets()) == 0 {
+			return errors.New("upload failed on all targets")
+		}
+	}
+
+	outputs, err := runDocument(f, "AWS-RunShellScript", map[string]string{"commands": scripts[len(scripts)-1]}, command.Timeout, abort)
+	if err != nil {
+		return cleanup(err)
+	}
+	for _, output := range outputs {
+		if output.Status != "Success" || output.Error != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("upload failed on %d target(s)", failed)
+	}
+	return nil
+}
+
+func (command *CpCommand) download(src, dst string) error {
+	ssmOpts, err := command.SSMOpts.Parse()
+	if err != nil {
+		return err
+	}
+	// Files which are too large for the inline command output are read from S3.
+	ssmOpts.ExtendOutput = ssmOpts.S3Bucket != ""
+
+	managers, err := newManagers(*ssmOpts)
+	if err != nil {
+		return err
+	}
+	f, err := setTargets(managers, command.TargetOpts, "Linux")
+	if err != nil {
+		return errors.Wrap(err, "failed to set targets")
+	}
+	multiple := len(f.Targets()) > 1
+	fmt.Printf("Downloading %s to %s\n", src, dst)
+
+	var failed int
+	_, err = collectOutput(f, "AWS-RunShellScript", map[string]string{"commands": DownloadScript(src)}, command.Timeout, interruptHandler(), func(output *manager.CommandOutput) error {
+		result := *output
+		if output.Status == "Success" && output.Error == nil {
+			result.Output, result.Error = saveDownload(output, src, dst, multiple)
+		}
+		if result.Status != "Success" || result.Error != nil {
+			failed++
+		}
+		return PrintCommandOutput(os.Stdout, &result)
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return errors.Errorf("download failed on %d target(s)", failed)
+	}
+	return nil
+}
+
+// Decode a downloaded file from the command output and write it to the destination.
+func saveDownload(output *manager.CommandOutput, src, dst string, multiple bool) (string, error) {
+	data, err := ParseDownload(output.Output)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(src)
+	switch {
+	case multiple:
+		dst = filepath.Join(dst, output.AccountID, output.InstanceID, name)
+	case strings.HasSuffix(dst, "/") || strings.HasSuffix(dst, string(filepath.Separator)):
+		dst = filepath.Join(dst, name)
+	default:
+		if info, err := os.Stat(dst); err == nil && info.IsDir() {
+			dst = filepath.Join(dst, name)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Downloaded %d bytes to %s (sha256 %s)", len(data), dst, checksum(data)), nil
+}
+
+// ParseRemotePath splits a path on the form [target]:path. Local paths are returned with
+// remote set to false.
+func ParseRemotePath(arg string) (target, path string, remote bool) {
+	i := strings.Index(arg, ":")
+	if i < 0 || strings.ContainsAny(arg[:i], `/\`) || (i == 1 && runtime.GOOS == "windows") {
+		return "", arg, false
+	}
+	return arg[:i], arg[i+1:], true
+}
+
+// UploadOptions are the owner and mode to set on uploaded files.
+type UploadOptions struct {
+	Owner string
+	Mode  string
+}
+
+// Validate the options, which are inserted in shell scripts.
+func (o UploadOptions) Validate() error {
+	if o.Owner != "" && !ownerPattern.MatchString(o.Owner) {
+		return errors.Errorf("invalid owner: %s", o.Owner)
+	}
+	if o.Mode != "" && !modePattern.MatchString(o.Mode) {
+		return errors.Errorf("invalid mode: %s", o.Mode)
+	}
+	return nil
+}
+
+// UploadScripts returns shell scripts which upload a file in base64 encoded chunks. Each
+// script appends a chunk to a temporary file next to the destination, and the last one
+// decodes it and moves it into place. If the destination is a directory, the file is
+// placed in it using the given name. The temporary file is removed if a script fails,
+// and UploadCleanupScript removes it if the upload is aborted between scripts.
+func UploadScripts(data []byte, name, dst string, opts UploadOptions) []string {
+	sum := checksum(data)
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var scripts []string
+	for i := 0; i == 0 || i < len(encoded); i += uploadChunkSize {
+		end := i + uploadChunkSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		redirect := ">>"
+		if i == 0 {
+			redirect = ">"
+		}
+		var b strings.Builder
+		b.WriteString(uploadPreamble(name, dst, sum))
+		b.WriteString("trap '[ $? -eq 0 ] || rm -f \"$tmp.b64\"' EXIT\n")
+		fmt.Fprintf(&b, "printf '%%s' '%s' %s\"$tmp.b64\"\n", encoded[i:end], redirect)
+		scripts = append(scripts, b.String())
+	}
+
+	last := len(scripts) - 1
+	scripts[last] += "trap 'rm -f \"$tmp\" \"$tmp.b64\"' EXIT\n" +
+		"base64 -d \"$tmp.b64\" >\"$tmp\"\n" +
+		uploadFinalize(len(data), sum, opts)
+	return scripts
+}
+
+// UploadCleanupScript returns a shell script which removes the temporary files
+// of an upload which was aborted.
+func UploadCleanupScript(data []byte, name, dst string) string {
+	return uploadPreamble(name, dst, checksum(data)) +
+		"rm -f \"$tmp\" \"$tmp.b64\"\n"
+}
+
+// StagedUploadScript returns a shell script which downloads a staged file from a (presigned) URL
+// and moves it into place.
+func StagedUploadScript(url string, data []byte, name, dst string, opts UploadOptions) string {
+	sum := checksum(data)
+	return uploadPreamble(name, dst, sum) +
+		"trap 'rm -f \"$tmp\"' EXIT\n" +
+		fmt.Sprintf("if command -v curl >/dev/null 2>&1; then curl -fsSL -o \"$tmp\" %s; else wget -q -O \"$tmp\" %s; fi\n", shellQuote(url), shellQuote(url)) +
+		uploadFinalize(len(data), sum, opts)
+}
+
+// DownloadScript returns a shell script which writes a header with the checksum and size of
+// a file, followed by its (gzipped, if available) base64 encoded content. The file is copied
+// first so that the checksum matches the content even if the file is being written to.
+func DownloadScript(src string) string {
+	return "set -e\n" +
+		fmt.Sprintf("src=%s\n", shellQuote(src)) +
+		"if [ ! -f \"$src\" ]; then echo \"not a regular file: $src\" >&2; exit 1; fi\n" +
+		"tmp=$(mktemp)\n" +
+		"trap 'rm -f \"$tmp\"' EXIT\n" +
+		"cp \"$src\" \"$tmp\"\n" +
+		"sum=$(sha256sum \"$tmp\" | cut -d' ' -f1)\n" +
+		"size=$(stat -c %s \"$tmp\")\n" +
+		"if command -v gzip >/dev/null 2>&1; then\n" +
+		"  echo \"" + downloadHeader + " $sum $size gzip\"\n" +
+		"  gzip -c \"$tmp\" | base64\n" +
+		"else\n" +
+		"  echo \"" + downloadHeader + " $sum $size plain\"\n" +
+		"  base64 \"$tmp\"\n" +
+		"fi\n"
+}
+
+// ParseDownload decodes the output of a DownloadScript and verifies the size and checksum.
+func ParseDownload(output string) ([]byte, error) {
+	if strings.Contains(output, "--output truncated--") {
+		return nil, errors.New("output was truncated, use --s3-bucket to download files larger than the inline output limit")
+	}
+	i := strings.Index(output, downloadHeader+" ")
+	if i < 0 {
+		return nil, errors.New("missing download header in output")
+	}
+	lines := strings.SplitN(output[i:], "\n", 2)
+	fields := strings.Fields(lines[0])
+	if len(fields) != 4 || len(lines) != 2 {
+		return nil, errors.Errorf("invalid download header: %s", lines[0])
+	}
+	sum, encoding := fields[1], fields[3]
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, errors.Errorf("invalid size in download header: %s", fields[2])
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(lines[1]), ""))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode output")
+	}
+	switch encoding {
+	case "gzip":
+		rd, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decompress output")
+		}
+		if data, err = ioutil.ReadAll(rd); err != nil {
+			return nil, errors.Wrap(err, "failed to decompress output")
+		}
+	case "plain":
+	default:
+		return nil, errors.Errorf("unknown encoding in download header: %s", encoding)
+	}
+
+	if len(data) != size {
+		return nil, errors.Errorf("size mismatch: expected %d bytes, got %d", size, len(data))
+	}
+	if checksum(data) != sum {
+		return nil, errors.New("checksum mismatch")
+	}
+	return data, nil
+}
+
+func uploadPreamble(name, dst, sum string) string {
+	return "set -e\n" +
+		fmt.Sprintf("dst=%s\n", shellQuote(dst)) +
+		fmt.Sprintf("if [ -d \"$dst\" ]; then dst=\"${dst%%/}/\"%s; fi\n", shellQuote(name)) +
+		fmt.Sprintf("tmp=\"$dst.ssm-sh-%s\"\n", sum[:12])
+}
+
+// Verify the checksum, set owner and mode, move the file into place and verify the result.
+func uploadFinalize(size int, sum string, opts UploadOptions) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "if [ \"$(sha256sum \"$tmp\" | cut -d' ' -f1)\" != %s ]; then echo \"checksum mismatch for $dst\" >&2; exit 1; fi\n", shellQuote(sum))
+	b.WriteString("if [ -e \"$dst\" ]; then chown --reference=\"$dst\" \"$tmp\" 2>/dev/null || true; chmod --reference=\"$dst\" \"$tmp\" 2>/dev/null || true; fi\n")
+	if opts.Owner != "" {
+		fmt.Fprintf(&b, "chown %s \"$tmp\"\n", opts.Owner)
+	}
+	if opts.Mode != "" {
+		fmt.Fprintf(&b, "chmod %s \"$tmp\"\n", opts.Mode)
+	}
+	b.WriteString("mv -f \"$tmp\" \"$dst\"\n")
+
+	if opts.Owner != "" {
+		format, expected := ownerFormat(opts.Owner)
+		fmt.Fprintf(&b, "if [ \"$(stat -c '%s' \"$dst\")\" != %s ]; then echo \"unexpected owner for $dst: $(stat -c '%%U:%%G' \"$dst\")\" >&2; exit 1; fi\n", format, shellQuote(expected))
+	}
+	if opts.Mode != "" {
+		mode := strings.TrimLeft(opts.Mode, "0")
+		if mode == "" {
+			mode = "0"
+		}
+		fmt.Fprintf(&b, "if [ \"$(stat -c '%%a' \"$dst\")\" != %s ]; then echo \"unexpected mode for $dst: $(stat -c '%%a' \"$dst\")\" >&2; exit 1; fi\n", shellQuote(mode))
+	}
+	fmt.Fprintf(&b, "if [ \"$(sha256sum \"$dst\" | cut -d' ' -f1)\" != %s ]; then echo \"checksum mismatch for $dst\" >&2; exit 1; fi\n", shellQuote(sum))
+	fmt.Fprintf(&b, "echo \"Uploaded %d bytes to $dst ($(stat -c 'owner %%U:%%G, mode %%a' \"$dst\"), sha256 %s)\"\n", size, sum)
+	return b.String()
+}
+
+// Return the stat format and expected value for an owner, using ids if the owner is numeric.
+func ownerFormat(owner string) (string, string) {
+	parts := strings.SplitN(owner, ":", 2)
+	format := "%U"
+	if isNumeric(parts[0]) {
+		format = "%u"
+	}
+	if len(parts) == 2 {
+		if isNumeric(parts[1]) {
+			format += ":%g"
+		} else {
+			format += ":%G"
+		}
+	}
+	return format, owner
+}
+
+func isNumeric(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Quote a string for use in a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
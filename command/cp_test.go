@@ -0,0 +1,191 @@
+package command_test
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRemotePath(t *testing.T) {
+	tests := []struct {
+		arg    string
+		target string
+		path   string
+		remote bool
+	}{
+		{"i-00000000000000001:/etc/app.conf", "i-00000000000000001", "/etc/app.conf", true},
+		{"web-*:/tmp/", "web-*", "/tmp/", true},
+		{":/etc/app.conf", "", "/etc/app.conf", true},
+		{"./local.conf", "", "./local.conf", false},
+		{"./dir:with:colons", "", "./dir:with:colons", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.arg, func(t *testing.T) {
+			target, path, remote := command.ParseRemotePath(tc.arg)
+			assert.Equal(t, tc.target, target)
+			assert.Equal(t, tc.path, path)
+			assert.Equal(t, tc.remote, remote)
+		})
+	}
+}
+
+// Run the upload scripts with a local shell to check that they produce the expected file.
+func TestUploadScripts(t *testing.T) {
+	for _, tool := range []string{"sh", "base64", "sha256sum", "stat"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not available", tool)
+		}
+	}
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("unable to look up the current user")
+	}
+
+	dir, err := ioutil.TempDir("", "ssm-sh-cp")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 100*1024)
+	rand.Read(data)
+
+	run := func(scripts []string) (string, error) {
+		var out []byte
+		var err error
+		for _, script := range scripts {
+			if out, err = exec.Command("sh", "-c", script).CombinedOutput(); err != nil {
+				break
+			}
+		}
+		return string(out), err
+	}
+
+	t.Run("Upload in chunks", func(t *testing.T) {
+		dst := filepath.Join(dir, "app.conf")
+		scripts := command.UploadScripts(data, "local.conf", dst, command.UploadOptions{Owner: current.Username, Mode: "0640"})
+		assert.Equal(t, 3, len(scripts))
+
+		out, err := run(scripts)
+		assert.Nil(t, err, out)
+		assert.Contains(t, out, "Uploaded 102400 bytes to "+dst)
+
+		actual, err := ioutil.ReadFile(dst)
+		assert.Nil(t, err)
+		assert.Equal(t, data, actual)
+		info, err := os.Stat(dst)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+		files, _ := ioutil.ReadDir(dir)
+		assert.Equal(t, 1, len(files), "temporary files are removed")
+	})
+
+	t.Run("Failed chunks are removed", func(t *testing.T) {
+		dst := filepath.Join(dir, "failed.conf")
+		scripts := command.UploadScripts(data, "local.conf", dst, command.UploadOptions{})
+		_, err := run([]string{scripts[0], scripts[1] + "false\n"})
+		assert.NotNil(t, err)
+
+		matches, _ := filepath.Glob(dst + "*")
+		assert.Empty(t, matches, "temporary files are removed")
+	})
+
+	t.Run("Aborted uploads are removed", func(t *testing.T) {
+		dst := filepath.Join(dir, "aborted.conf")
+		scripts := command.UploadScripts(data, "local.conf", dst, command.UploadOptions{})
+		out, err := run(scripts[:2])
+		assert.Nil(t, err, out)
+		matches, _ := filepath.Glob(dst + "*")
+		assert.Len(t, matches, 1)
+
+		out, err = run([]string{command.UploadCleanupScript(data, "local.conf", dst)})
+		assert.Nil(t, err, out)
+		matches, _ = filepath.Glob(dst + "*")
+		assert.Empty(t, matches, "temporary files are removed")
+	})
+
+	t.Run("Upload to a directory", func(t *testing.T) {
+		out, err := run(command.UploadScripts([]byte("hello\n"), "local.conf", dir, command.UploadOptions{}))
+		assert.Nil(t, err, out)
+		actual, err := ioutil.ReadFile(filepath.Join(dir, "local.conf"))
+		assert.Nil(t, err)
+		assert.Equal(t, "hello\n", string(actual))
+	})
+
+	t.Run("Unexpected owner fails", func(t *testing.T) {
+		dst := filepath.Join(dir, "owner.conf")
+		_, err := run(command.UploadScripts([]byte("hello\n"), "local.conf", dst, command.UploadOptions{Owner: "ssm-sh-missing-user"}))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Invalid options are rejected", func(t *testing.T) {
+		assert.NotNil(t, command.UploadOptions{Owner: "root; rm -rf /"}.Validate())
+		assert.NotNil(t, command.UploadOptions{Mode: "rwx"}.Validate())
+		assert.Nil(t, command.UploadOptions{Owner: "app:app", Mode: "0644"}.Validate())
+	})
+}
+
+// Run the download script with a local shell and decode the output.
+func TestDownloadScript(t *testing.T) {
+	for _, tool := range []string{"sh", "base64", "sha256sum", "stat", "mktemp"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not available", tool)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "ssm-sh-cp")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+	src := filepath.Join(dir, "app.log")
+	assert.Nil(t, ioutil.WriteFile(src, data, 0644))
+
+	out, err := exec.Command("sh", "-c", command.DownloadScript(src)).CombinedOutput()
+	assert.Nil(t, err, string(out))
+
+	actual, err := command.ParseDownload(string(out))
+	assert.Nil(t, err)
+	assert.Equal(t, data, actual)
+
+	t.Run("Missing file fails", func(t *testing.T) {
+		_, err := exec.Command("sh", "-c", command.DownloadScript(filepath.Join(dir, "missing.log"))).CombinedOutput()
+		assert.NotNil(t, err)
+	})
+}
+
+func TestParseDownload(t *testing.T) {
+	// printf 'hello\n' | base64
+	output := "ssm-sh-download 5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03 6 plain\naGVsbG8K\n"
+
+	actual, err := command.ParseDownload(output)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\n", string(actual))
+
+	tests := []struct {
+		description string
+		output      string
+		expected    string
+	}{
+		{"Truncated output", output[:40] + "\n--output truncated--", "output was truncated, use --s3-bucket to download files larger than the inline output limit"},
+		{"Missing header", "aGVsbG8K\n", "missing download header in output"},
+		{"Wrong size", strings.Replace(output, " 6 ", " 7 ", 1), "size mismatch: expected 7 bytes, got 6"},
+		{"Wrong checksum", strings.Replace(output, "aGVsbG8K", "aGVsbG9K", 1), "checksum mismatch"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := command.ParseDownload(tc.output)
+			assert.EqualError(t, err, tc.expected)
+		})
+	}
+}
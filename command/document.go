@@ -0,0 +1,188 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+)
+
+// DocumentPushCommand contains all arguments for document push command
+type DocumentPushCommand struct {
+	Name       string `short:"n" long:"name" description:"Name of the document (defaults to the file name without extension)."`
+	SetDefault bool   `long:"set-default" description:"Make the new version the default version of the document."`
+}
+
+func (command *DocumentPushCommand) Usage() string {
+	return "[push-OPTIONS] file"
+}
+
+// Execute document push command
+func (command *DocumentPushCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected the path to a document (.json, .yaml or .yml)")
+	}
+	format, err := documentFormat(args[0])
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return errors.Wrap(err, "failed to read document")
+	}
+	name := command.Name
+	if name == "" {
+		name = documentName(args[0])
+	}
+
+	m, err := newDocumentManager()
+	if err != nil {
+		return err
+	}
+	pushed, err := m.PushDocument(name, string(content), format, command.SetDefault)
+	if err != nil {
+		return err
+	}
+	switch {
+	case pushed.Created:
+		fmt.Printf("Created %s (version %s)\n", name, pushed.Version)
+	case pushed.Unchanged && command.SetDefault:
+		fmt.Printf("%s is unchanged, version %s is the default\n", name, pushed.Version)
+	case pushed.Unchanged:
+		fmt.Printf("%s is unchanged (version %s)\n", name, pushed.Version)
+	case command.SetDefault:
+		fmt.Printf("Updated %s to version %s (default)\n", name, pushed.Version)
+	default:
+		fmt.Printf("Updated %s to version %s (use --set-default to make it the default)\n", name, pushed.Version)
+	}
+	return nil
+}
+
+// DocumentPullCommand contains all arguments for document pull command
+type DocumentPullCommand struct {
+	Version string `long:"version" description:"Version of the document (defaults to the default version)."`
+	Output  string `short:"o" long:"output" description:"File to write the document to (defaults to <name>.yaml). Use - for stdout."`
+	Force   bool   `short:"f" long:"force" description:"Overwrite the file if it exists."`
+}
+
+func (command *DocumentPullCommand) Usage() string {
+	return "[pull-OPTIONS] name"
+}
+
+// Execute document pull command
+func (command *DocumentPullCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected the name of a document")
+	}
+	output := command.Output
+	if output == "" {
+		output = documentFile(args[0])
+	}
+	var format string
+	if output != "-" {
+		f, err := documentFormat(output)
+		if err != nil {
+			return err
+		}
+		format = f
+		if _, err := os.Stat(output); err == nil && !command.Force {
+			return errors.Errorf("%s already exists, use --force to overwrite it", output)
+		}
+	}
+
+	m, err := newDocumentManager()
+	if err != nil {
+		return err
+	}
+	content, err := m.GetDocument(args[0], command.Version, format)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	if output == "-" {
+		_, err := fmt.Print(content)
+		return err
+	}
+	if err := ioutil.WriteFile(output, []byte(content), 0644); err != nil {
+		return errors.Wrap(err, "failed to write document")
+	}
+	fmt.Printf("Wrote %s to %s\n", args[0], output)
+	return nil
+}
+
+// DocumentDeleteCommand contains all arguments for document delete command
+type DocumentDeleteCommand struct {
+	Force bool `short:"f" long:"force" description:"Delete the document without asking for confirmation."`
+}
+
+func (command *DocumentDeleteCommand) Usage() string {
+	return "[delete-OPTIONS] name"
+}
+
+// Execute document delete command
+func (command *DocumentDeleteCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected the name of a document")
+	}
+	if !command.Force {
+		ok, err := confirm(fmt.Sprintf("Delete %s and all its versions?", args[0]))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+
+	m, err := newDocumentManager()
+	if err != nil {
+		return err
+	}
+	if err := m.DeleteDocument(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %s\n", args[0])
+	return nil
+}
+
+// Create a manager for the document commands, which work in a single account
+// (the default one, or the account of a single assumed role).
+func newDocumentManager() (*manager.Manager, error) {
+	managers, err := newManagers(manager.Opts{})
+	if err != nil {
+		return nil, err
+	}
+	if len(managers) > 1 {
+		return nil, errors.Errorf("document commands work in a single account, but %d roles are set", len(managers))
+	}
+	return managers[0], nil
+}
+
+// Returns the name of a document pushed from a file: the file name without extension.
+func documentName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// Returns the default file for a pulled document. Documents can be given by
+// ARN (arn:aws:ssm:<region>:<account>:document/<name>), so only the name is used.
+func documentFile(name string) string {
+	return name[strings.LastIndex(name, "/")+1:] + ".yaml"
+}
+
+// Returns the document format (JSON or YAML) for a file based on its extension.
+func documentFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "JSON", nil
+	case ".yaml", ".yml":
+		return "YAML", nil
+	}
+	return "", errors.Errorf("unknown document format: %s (expected .json, .yaml or .yml)", path)
+}
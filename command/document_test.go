@@ -0,0 +1,68 @@
+package command_test
+
+import (
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocumentFormat(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		err      bool
+	}{
+		{path: "restart-app.json", expected: "JSON"},
+		{path: "docs/restart-app.yaml", expected: "YAML"},
+		{path: "restart-app.YML", expected: "YAML"},
+		{path: "restart-app.txt", err: true},
+		{path: "restart-app", err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			format, err := command.DocumentFormat(tc.path)
+			if tc.err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, format)
+		})
+	}
+}
+
+func TestDocumentName(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "restart-app.yaml", expected: "restart-app"},
+		{path: "docs/restart-app.json", expected: "restart-app"},
+		{path: "docs.v2/restart-app", expected: "restart-app"},
+		{path: "restart.app.yml", expected: "restart.app"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			assert.Equal(t, tc.expected, command.DocumentName(tc.path))
+		})
+	}
+}
+
+func TestDocumentFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "restart-app", expected: "restart-app.yaml"},
+		{name: "arn:aws:ssm:eu-west-1:111111111111:document/restart-app", expected: "restart-app.yaml"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, command.DocumentFile(tc.name))
+		})
+	}
+}
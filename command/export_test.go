@@ -0,0 +1,105 @@
+package command
+
+import (
+	"io"
+
+	"github.com/itsdalmo/ssm-sh/manager"
+)
+
+// Internals which are exported for the tests in command_test.
+type Fleet = fleet
+
+var NewFleet = newFleet
+
+// ShellContinues returns true if a shell would read more lines after input.
+func ShellContinues(input string) bool {
+	return (&shell{}).continues(input)
+}
+
+var BackgroundCommand = backgroundCommand
+
+var RunDocumentWithCanary = runDocumentWithCanary
+
+var Proxy = proxy
+
+var (
+	ParseSample = parseSample
+	SetTargets  = setTargets
+)
+
+type Shell = shell
+
+// NewTestShell creates a shell for the fleet which does not record a transcript.
+func NewTestShell(f *Fleet, timeout int) *Shell {
+	sh, err := newShell(f, timeout, "")
+	if err != nil {
+		panic(err)
+	}
+	return sh
+}
+
+var (
+	NewShell   = newShell
+	ScriptPath = scriptPath
+)
+
+func (sh *shell) Handle(line string) error { return sh.handle(line) }
+func (sh *shell) Close()                   { sh.close() }
+func (sh *shell) Failed() bool             { return sh.failed }
+func (sh *shell) SetFailed(failed bool)    { sh.failed = failed }
+
+func (sh *shell) RunScript(r io.Reader, continueOnError bool) error {
+	return sh.runScript(r, continueOnError)
+}
+
+// JobStatus returns the status of each job, and waits for the jobs to finish if wait is set.
+func (sh *shell) JobStatus(wait bool) []string {
+	var statuses []string
+	for _, job := range sh.jobs {
+		if wait {
+			<-job.done
+		}
+		job.mu.Lock()
+		status := job.status
+		job.mu.Unlock()
+		if status == "" {
+			status = "Running"
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// LookupBuiltin returns the name and arguments of the built-in for a line, or
+// an empty name if the line is not a built-in.
+func LookupBuiltin(line string) (string, []string) {
+	b, args := (&shell{}).lookupBuiltin(line)
+	if b == nil {
+		return "", nil
+	}
+	return b.name, args
+}
+
+func (sh *shell) Targets() []string               { return sh.fleet.Targets() }
+func (sh *shell) SetSkipOffline(skipOffline bool) { sh.skipOffline = skipOffline }
+
+var CompileTarget = compileTarget
+
+var (
+	LoadAccounts       = loadAccounts
+	NewManagers        = newManagers
+	NewDocumentManager = newDocumentManager
+)
+
+var (
+	DocumentFormat = documentFormat
+	DocumentName   = documentName
+	DocumentFile   = documentFile
+)
+
+func (sh *shell) RemoteFiles(dir string) []string { return sh.remoteFiles(dir) }
+
+// SetAbort replaces the interrupt handler, so that tests can send interrupts.
+func (sh *shell) SetAbort(abort <-chan bool) { sh.abort = abort }
+
+func (sh *shell) Last() []*manager.CommandOutput { return sh.last }
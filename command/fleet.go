@@ -0,0 +1,572 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+)
+
+// fleet holds the targets for each account (manager).
+type fleet struct {
+	accounts []*fleetAccount
+}
+
+type fleetAccount struct {
+	manager   *manager.Manager
+	targets   []string
+	instances []*manager.Instance
+}
+
+// Create a fleet without any targets.
+func newFleet(managers []*manager.Manager) *fleet {
+	f := &fleet{}
+	for _, m := range managers {
+		f.accounts = append(f.accounts, &fleetAccount{manager: m})
+	}
+	return f
+}
+
+// Instances returns the managed instances in the account. The list is
+// only fetched once.
+func (a *fleetAccount) Instances() ([]*manager.Instance, error) {
+	if a.instances != nil {
+		return a.instances, nil
+	}
+	instances, err := a.manager.ListInstances(50, nil)
+	if err != nil {
+		if id := a.manager.AccountID(); id != "" {
+			return nil, errors.Wrapf(err, "failed to list instances in account %s", id)
+		}
+		return nil, errors.Wrap(err, "failed to list instances")
+	}
+	a.instances = instances
+	return instances, nil
+}
+
+// Add targets which are not already present.
+func (a *fleetAccount) add(targets ...string) {
+	for _, target := range targets {
+		var exists bool
+		for _, t := range a.targets {
+			if t == target {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			a.targets = append(a.targets, target)
+		}
+	}
+}
+
+// Assign targets to the account they belong to. With a single account all
+// targets are assigned directly, otherwise the managed instances in each
+// account are listed to look up where each target belongs.
+func (f *fleet) Assign(targets []string) error {
+	if len(f.accounts) == 1 {
+		f.accounts[0].add(targets...)
+		return nil
+	}
+
+	owner := make(map[string]*fleetAccount)
+	for _, a := range f.accounts {
+		instances, err := a.Instances()
+		if err != nil {
+			return err
+		}
+		for _, instance := range instances {
+			owner[instance.ID()] = a
+		}
+	}
+
+	for _, target := range targets {
+		a, ok := owner[target]
+		if !ok {
+			return errors.Errorf("target not found in any account: %s", target)
+		}
+		a.add(target)
+	}
+	return nil
+}
+
+// Match adds the managed instances with a name or instance ID matching the
+// regular expression as targets, and returns the number of matches.
+func (f *fleet) Match(regex *regexp.Regexp) (int, error) {
+	return f.Include(func(instance *manager.Instance) bool {
+		return regex.MatchString(instance.Name) || regex.MatchString(instance.ID())
+	})
+}
+
+// Include adds the managed instances for which match returns true as targets,
+// and returns the number of matches.
+func (f *fleet) Include(match func(*manager.Instance) bool) (int, error) {
+	var n int
+	for _, a := range f.accounts {
+		instances, err := a.Instances()
+		if err != nil {
+			return 0, err
+		}
+		for _, instance := range instances {
+			if match(instance) {
+				a.add(instance.ID())
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+// Remove the targets for which match returns true, and return the number of
+// targets which were removed. Targets which are not found among the managed
+// instances are only matched on their instance ID.
+func (f *fleet) Remove(match func(*manager.Instance) bool) (int, error) {
+	var n int
+	for _, a := range f.accounts {
+		if len(a.targets) == 0 {
+			continue
+		}
+		instances, err := a.Instances()
+		if err != nil {
+			return 0, err
+		}
+		lookup := make(map[string]*manager.Instance)
+		for _, instance := range instances {
+			lookup[instance.ID()] = instance
+		}
+
+		var targets []string
+		for _, target := range a.targets {
+			instance, ok := lookup[target]
+			if !ok {
+				instance = &manager.Instance{InstanceID: target}
+			}
+			if match(instance) {
+				n++
+				continue
+			}
+			targets = append(targets, target)
+		}
+		a.targets = targets
+	}
+	return n, nil
+}
+
+// Intersect adds the instance ids returned by lookup as targets, if they are
+// managed by SSM, and returns the number of targets which were added. Lookup
+// is called once per account, and only has to succeed for one of them. A
+// warning is printed for each account where it fails.
+func (f *fleet) Intersect(lookup func(*manager.Manager) ([]string, error)) (int, error) {
+	var n int
+	var errs []error
+	for _, a := range f.accounts {
+		ids, err := lookup(a.manager)
+		if err != nil {
+			if account := a.manager.AccountID(); account != "" {
+				err = errors.Wrapf(err, "account %s", account)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		instances, err := a.Instances()
+		if err != nil {
+			return 0, err
+		}
+		managed := make(map[string]bool)
+		for _, instance := range instances {
+			managed[instance.ID()] = true
+		}
+		for _, id := range ids {
+			if managed[id] {
+				a.add(id)
+				n++
+			}
+		}
+	}
+	var last error
+	if len(errs) > 0 && len(errs) == len(f.accounts) {
+		errs, last = errs[:len(errs)-1], errs[len(errs)-1]
+	}
+	for _, err := range errs {
+		fmt.Printf("Warning: %s\n", err)
+	}
+	if last != nil {
+		return 0, last
+	}
+	return n, nil
+}
+
+// Where adds the managed instances which match the expression as targets.
+func (f *fleet) Where(where *manager.Expression) error {
+	for _, a := range f.accounts {
+		instances, err := a.manager.ListInstancesWhere(50, nil, where)
+		if err != nil {
+			if id := a.manager.AccountID(); id != "" {
+				return errors.Wrapf(err, "failed to list instances in account %s", id)
+			}
+			return errors.Wrap(err, "failed to list instances")
+		}
+		for _, instance := range instances {
+			a.add(instance.ID())
+		}
+	}
+	return nil
+}
+
+// Preflight checks the SSM agent status of all targets. Unknown targets are
+// reported as an error, while targets which are offline or on a different
+// platform (if set) are reported as warnings. Offline targets are removed
+// from the fleet if skipOffline is true.
+func (f *fleet) Preflight(platform string, skipOffline bool) error {
+	var unknown []string
+	for _, a := range f.accounts {
+		if len(a.targets) == 0 {
+			continue
+		}
+		statuses, err := a.manager.DescribeInstanceStatus(a.targets)
+		if err != nil {
+			return errors.Wrap(err, "failed to check target status")
+		}
+		lookup := make(map[string]*manager.InstanceStatus)
+		for _, status := range statuses {
+			lookup[status.InstanceID] = status
+		}
+
+		var targets []string
+		for _, target := range a.targets {
+			status, ok := lookup[target]
+			if !ok {
+				unknown = append(unknown, target)
+				continue
+			}
+			if platform != "" && status.PlatformType != "" && status.PlatformType != platform {
+				fmt.Printf("Warning: %s is running %s (%s)\n", target, status.PlatformType, status.PlatformName)
+			}
+			if !status.Online() {
+				if skipOffline {
+					fmt.Printf("Skipping %s: %s\n", target, status.PingStatus)
+					continue
+				}
+				fmt.Printf("Warning: %s is %s\n", target, status.PingStatus)
+			}
+			targets = append(targets, target)
+		}
+		a.targets = targets
+	}
+
+	if len(unknown) > 0 {
+		return errors.Errorf("targets are not managed by ssm: %s", unknown)
+	}
+	if len(f.Targets()) == 0 {
+		return errors.New("no online targets")
+	}
+	return nil
+}
+
+// Split the fleet into one with the first n targets, and one with the rest.
+func (f *fleet) Split(n int) (*fleet, *fleet) {
+	first, rest := &fleet{}, &fleet{}
+	for _, a := range f.accounts {
+		i := n
+		if i > len(a.targets) {
+			i = len(a.targets)
+		}
+		n -= i
+		first.accounts = append(first.accounts, &fleetAccount{manager: a.manager, targets: a.targets[:i], instances: a.instances})
+		rest.accounts = append(rest.accounts, &fleetAccount{manager: a.manager, targets: a.targets[i:], instances: a.instances})
+	}
+	return first, rest
+}
+
+// Filter returns a fleet with the targets for which keep returns true.
+func (f *fleet) Filter(keep func(target string) bool) *fleet {
+	filtered := &fleet{}
+	for _, a := range f.accounts {
+		var targets []string
+		for _, target := range a.targets {
+			if keep(target) {
+				targets = append(targets, target)
+			}
+		}
+		filtered.accounts = append(filtered.accounts, &fleetAccount{manager: a.manager, targets: targets, instances: a.instances})
+	}
+	return filtered
+}
+
+// Sample keeps n randomly chosen targets. Targets are sorted before they are
+// shuffled, so that the same seed always gives the same sample.
+func (f *fleet) Sample(n int, seed int64) {
+	type target struct {
+		account *fleetAccount
+		id      string
+	}
+	var targets []target
+	for _, a := range f.accounts {
+		for _, id := range a.targets {
+			targets = append(targets, target{account: a, id: id})
+		}
+		a.targets = nil
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].id < targets[j].id
+	})
+
+	rnd := rand.New(rand.NewSource(seed))
+	rnd.Shuffle(len(targets), func(i, j int) {
+		targets[i], targets[j] = targets[j], targets[i]
+	})
+	if n < len(targets) {
+		targets = targets[:n]
+	}
+	for _, t := range targets {
+		t.account.targets = append(t.account.targets, t.id)
+	}
+}
+
+// Targets returns the targets for all accounts.
+func (f *fleet) Targets() []string {
+	var targets []string
+	for _, a := range f.accounts {
+		targets = append(targets, a.targets...)
+	}
+	return targets
+}
+
+// RunCommand starts a command in every account which has targets.
+func (f *fleet) RunCommand(name string, parameters map[string]string) (*invocation, error) {
+	inv := &invocation{}
+	for _, a := range f.accounts {
+		if len(a.targets) == 0 {
+			continue
+		}
+		commandID, err := a.manager.RunCommand(a.targets, name, parameters)
+		if err != nil {
+			// Abort whatever was started before returning the error.
+			inv.Abort()
+			if id := a.manager.AccountID(); id != "" {
+				return nil, errors.Wrapf(err, "account %s", id)
+			}
+			return nil, err
+		}
+		inv.commands = append(inv.commands, &accountCommand{
+			fleetAccount: a,
+			targets:      append([]string(nil), a.targets...),
+			commandID:    commandID,
+		})
+	}
+	return inv, nil
+}
+
+// invocation is a command started in one or more accounts.
+type invocation struct {
+	commands []*accountCommand
+}
+
+// accountCommand keeps its own copy of the targets, since the fleet can change
+// while the command is running.
+type accountCommand struct {
+	*fleetAccount
+	targets   []string
+	commandID string
+}
+
+// CommandIDs returns the command ID for each account.
+func (i *invocation) CommandIDs() []string {
+	var ids []string
+	for _, c := range i.commands {
+		ids = append(ids, c.commandID)
+	}
+	return ids
+}
+
+// Targets returns the targets of the command, prefixed with the account ID
+// when using multiple accounts.
+func (i *invocation) Targets() []string {
+	var targets []string
+	for _, c := range i.commands {
+		account := c.manager.AccountID()
+		for _, target := range c.targets {
+			if account != "" {
+				target = account + "/" + target
+			}
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// Pending returns the targets which are not in the outputs.
+func (i *invocation) Pending(outputs []*manager.CommandOutput) []string {
+	done := make(map[string]bool)
+	for _, output := range outputs {
+		instance := output.InstanceID
+		if output.AccountID != "" {
+			instance = output.AccountID + "/" + instance
+		}
+		done[instance] = true
+	}
+	var pending []string
+	for _, target := range i.Targets() {
+		if !done[target] {
+			pending = append(pending, target)
+		}
+	}
+	return pending
+}
+
+// Abort the command in all accounts.
+func (i *invocation) Abort() error {
+	for _, c := range i.commands {
+		if err := c.manager.AbortCommand(c.targets, c.commandID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCommandOutput merges the output from all accounts onto the receiving
+// channel, and closes it once all accounts are done.
+func (i *invocation) GetCommandOutput(ctx context.Context, out chan<- *manager.CommandOutput) {
+	defer close(out)
+	var wg sync.WaitGroup
+
+	for _, c := range i.commands {
+		wg.Add(1)
+		go func(c *accountCommand) {
+			defer wg.Done()
+			o := make(chan *manager.CommandOutput)
+			go c.manager.GetCommandOutput(ctx, c.targets, c.commandID, o)
+			for output := range o {
+				select {
+				case out <- output:
+				case <-ctx.Done():
+				}
+			}
+		}(c)
+	}
+
+	wg.Wait()
+}
+
+// Run a document on the fleet and print the output until all targets are
+// done, the timeout is reached or the user interrupts twice (abort is the
+// channel from interruptHandler). Returns the output which was received.
+func runDocument(f *fleet, name string, parameters map[string]string, timeout int, abort <-chan bool) ([]*manager.CommandOutput, error) {
+	fmt.Printf("Use ctrl-c to abort the command early.\n\n")
+	return collectOutput(f, name, parameters, timeout, abort, func(output *manager.CommandOutput) error {
+		return PrintCommandOutput(os.Stdout, output)
+	})
+}
+
+// Run a document on the targets and pass the output from each instance to the handler as it arrives.
+// The interrupt handler is created once per command and shared by all runs, since signals are
+// delivered to every handler which has been created.
+func collectOutput(f *fleet, name string, parameters map[string]string, timeout int, abort <-chan bool, handle func(*manager.CommandOutput) error) ([]*manager.CommandOutput, error) {
+	var outputs []*manager.CommandOutput
+
+	// Start the command
+	inv, err := f.RunCommand(name, parameters)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to run command")
+	}
+
+	// Catch sigterms to gracefully shut down
+	var interrupts int
+
+	// Get output
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	out := make(chan *manager.CommandOutput)
+	go inv.GetCommandOutput(ctx, out)
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Abort the command, so that it does not keep running after we return.
+			if err := inv.Abort(); err != nil {
+				return outputs, errors.Wrap(err, "failed to abort command after timeout")
+			}
+			return outputs, errors.New("timeout reached")
+		case <-abort:
+			interrupts++
+			err := inv.Abort()
+			if err != nil {
+				return outputs, errors.Wrap(err, "failed to abort command on sigterm")
+			}
+			if interrupts > 1 {
+				return outputs, errors.New("interrupted by user")
+			}
+		case output, open := <-out:
+			if !open {
+				return outputs, nil
+			}
+			outputs = append(outputs, output)
+			if err := handle(output); err != nil {
+				return outputs, errors.Wrap(err, "failed to print output")
+			}
+		}
+	}
+}
+
+// Run a document on a number of canary targets first, and ask for
+// confirmation (read from stdin) before running it on the remaining targets.
+// An interrupt while waiting for the answer counts as no.
+func runDocumentWithCanary(f *fleet, canary int, name string, parameters map[string]string, timeout int, abort <-chan bool, stdin io.Reader) error {
+	if canary <= 0 || canary >= len(f.Targets()) {
+		_, err := runDocument(f, name, parameters, timeout, abort)
+		return err
+	}
+
+	first, rest := f.Split(canary)
+	fmt.Printf("Running on canary targets: %s\n", first.Targets())
+	outputs, err := runDocument(first, name, parameters, timeout, abort)
+	if err != nil {
+		return err
+	}
+
+	var succeeded int
+	for _, output := range outputs {
+		if output.Status == "Success" && output.Error == nil {
+			succeeded++
+		}
+	}
+	question := fmt.Sprintf("\n%d of %d canary targets succeeded. Continue with the remaining %d targets?", succeeded, canary, len(rest.Targets()))
+
+	// Read the answer in the background, so that an interrupt is not left
+	// pending for the remaining targets.
+	type answer struct {
+		ok  bool
+		err error
+	}
+	answers := make(chan answer, 1)
+	go func() {
+		ok, err := confirmFrom(stdin, question)
+		answers <- answer{ok: ok, err: err}
+	}()
+	var a answer
+	select {
+	case <-abort:
+		fmt.Println()
+	case a = <-answers:
+	}
+	if a.err != nil {
+		return a.err
+	}
+	if !a.ok {
+		return errors.New("stopped after canary")
+	}
+
+	_, err = collectOutput(rest, name, parameters, timeout, abort, func(output *manager.CommandOutput) error {
+		return PrintCommandOutput(os.Stdout, output)
+	})
+	return err
+}
@@ -0,0 +1,291 @@
+package command_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+// Managed instances in the test account, with their name, env tag and ping status.
+var testInstances = []struct {
+	id, name, env, ping string
+}{
+	{"i-00000000000000001", "web-1", "prod", "Online"},
+	{"i-00000000000000002", "web-2", "staging", "Online"},
+	{"i-00000000000000003", "db-1", "prod", "Online"},
+	{"i-00000000000000004", "db-2", "staging", "ConnectionLost"},
+}
+
+// Create mocks with the test instances. Commands succeed immediately.
+func newTestMocks() (*manager.MockSSM, *manager.MockEC2) {
+	ssmMock := &manager.MockSSM{
+		CommandStatus: "Success",
+		CommandHistory: map[string]*struct {
+			Command *ssm.Command
+			Status  string
+		}{},
+	}
+	ec2Mock := &manager.MockEC2{Instances: make(map[string]*ec2.Instance)}
+	for _, instance := range testInstances {
+		ssmMock.Instances = append(ssmMock.Instances, &ssm.InstanceInformation{
+			InstanceId:       aws.String(instance.id),
+			PlatformName:     aws.String("Amazon Linux 2"),
+			PlatformType:     aws.String("Linux"),
+			PingStatus:       aws.String(instance.ping),
+			LastPingDateTime: aws.Time(time.Date(2018, time.January, 27, 13, 32, 0, 0, time.UTC)),
+		})
+		ec2Mock.Instances[instance.id] = &ec2.Instance{
+			InstanceId: aws.String(instance.id),
+			State:      &ec2.InstanceState{Name: aws.String("running")},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("Name"), Value: aws.String(instance.name)},
+				{Key: aws.String("env"), Value: aws.String(instance.env)},
+			},
+		}
+	}
+	return ssmMock, ec2Mock
+}
+
+// Create a manager for an account with the test instances.
+func newTestManager(account string, options ...manager.TestOption) *manager.Manager {
+	ssmMock, ec2Mock := newTestMocks()
+	options = append(options, manager.WithAccountID(account))
+	return manager.NewTestManager(ssmMock, nil, ec2Mock, options...)
+}
+
+func TestFleetIntersect(t *testing.T) {
+	groups := &manager.MockAutoScaling{
+		Groups: map[string][]string{
+			"web": {"i-00000000000000001", "i-00000000000000002", "i-00000000000000009"},
+		},
+	}
+	broken := &manager.MockAutoScaling{Error: true}
+
+	t.Run("Only managed instances are added", func(t *testing.T) {
+		f := command.NewFleet([]*manager.Manager{newTestManager("", manager.WithAutoScaling(groups))})
+		n, err := f.Intersect(func(m *manager.Manager) ([]string, error) {
+			return m.AutoScalingGroupInstances("web")
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []string{"i-00000000000000001", "i-00000000000000002"}, f.Targets())
+	})
+
+	t.Run("Failing accounts are skipped", func(t *testing.T) {
+		f := command.NewFleet([]*manager.Manager{
+			newTestManager("111111111111", manager.WithAutoScaling(broken)),
+			newTestManager("222222222222", manager.WithAutoScaling(groups)),
+		})
+		n, err := f.Intersect(func(m *manager.Manager) ([]string, error) {
+			return m.AutoScalingGroupInstances("web")
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, n)
+	})
+
+	t.Run("Fails if all accounts fail", func(t *testing.T) {
+		f := command.NewFleet([]*manager.Manager{
+			newTestManager("111111111111", manager.WithAutoScaling(broken)),
+			newTestManager("222222222222", manager.WithAutoScaling(broken)),
+		})
+		n, err := f.Intersect(func(m *manager.Manager) ([]string, error) {
+			return m.AutoScalingGroupInstances("web")
+		})
+		assert.EqualError(t, err, "account 222222222222: failed to describe auto scaling groups: expected")
+		assert.Equal(t, 0, n)
+	})
+}
+
+func TestFleetWhere(t *testing.T) {
+	where, err := manager.ParseExpression("tag:env=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Matches are added", func(t *testing.T) {
+		f := command.NewFleet([]*manager.Manager{newTestManager("")})
+		assert.Nil(t, f.Where(where))
+		assert.ElementsMatch(t, []string{"i-00000000000000001", "i-00000000000000003"}, f.Targets())
+	})
+
+	t.Run("Errors include the account", func(t *testing.T) {
+		broken, ec2Mock := newTestMocks()
+		broken.Error = true
+		f := command.NewFleet([]*manager.Manager{
+			newTestManager("111111111111"),
+			manager.NewTestManager(broken, nil, ec2Mock, manager.WithAccountID("222222222222")),
+		})
+		err := f.Where(where)
+		assert.EqualError(t, err, "failed to list instances in account 222222222222: failed to describe instance information: expected")
+	})
+}
+
+func TestFleetSample(t *testing.T) {
+	newTestFleet := func() *command.Fleet {
+		f := command.NewFleet([]*manager.Manager{newTestManager("")})
+		assert.Nil(t, f.Assign([]string{"i-00000000000000004", "i-00000000000000003", "i-00000000000000002", "i-00000000000000001"}))
+		return f
+	}
+
+	t.Run("Same seed gives the same sample", func(t *testing.T) {
+		a, b := newTestFleet(), newTestFleet()
+		a.Sample(2, 42)
+		b.Sample(2, 42)
+		assert.Len(t, a.Targets(), 2)
+		assert.Equal(t, a.Targets(), b.Targets())
+	})
+
+	t.Run("Sample larger than the fleet keeps all targets", func(t *testing.T) {
+		f := newTestFleet()
+		f.Sample(10, 1)
+		assert.ElementsMatch(t, newTestFleet().Targets(), f.Targets())
+	})
+}
+
+func TestFleetSplit(t *testing.T) {
+	f := command.NewFleet([]*manager.Manager{
+		newTestManager("111111111111"),
+		newTestManager("222222222222"),
+	})
+	assert.Nil(t, f.Assign([]string{"i-00000000000000001", "i-00000000000000002", "i-00000000000000003"}))
+
+	for _, n := range []int{0, 1, 2, 3, 4} {
+		first, rest := f.Split(n)
+		expected := n
+		if expected > 3 {
+			expected = 3
+		}
+		assert.Len(t, first.Targets(), expected)
+		assert.Equal(t, f.Targets(), append(first.Targets(), rest.Targets()...))
+	}
+}
+
+// Capture what fn writes to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	var b bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&b, r)
+		close(done)
+	}()
+	fn()
+	w.Close()
+	<-done
+	return b.String()
+}
+
+// Instances which received a command, in the order they were sent.
+func commandTargets(mocks ...*manager.MockSSM) []string {
+	var targets []string
+	for _, m := range mocks {
+		for _, c := range m.CommandHistory {
+			targets = append(targets, aws.StringValueSlice(c.Command.InstanceIds)...)
+		}
+	}
+	return targets
+}
+
+func TestRunDocumentWithCanary(t *testing.T) {
+	// The canary targets are i-1 in a failing account and i-2 in a succeeding account.
+	newCanaryFleet := func() (*command.Fleet, *manager.MockSSM, *manager.MockSSM) {
+		failing, failingEC2 := newTestMocks()
+		failing.Instances = failing.Instances[:1]
+		failing.CommandStatus = "Failed"
+		succeeding, succeedingEC2 := newTestMocks()
+		succeeding.Instances = succeeding.Instances[1:3]
+		f := command.NewFleet([]*manager.Manager{
+			manager.NewTestManager(failing, nil, failingEC2, manager.WithAccountID("111111111111")),
+			manager.NewTestManager(succeeding, nil, succeedingEC2, manager.WithAccountID("222222222222")),
+		})
+		assert.Nil(t, f.Assign([]string{"i-00000000000000001", "i-00000000000000002", "i-00000000000000003"}))
+		return f, failing, succeeding
+	}
+
+	t.Run("Runs on the rest after confirmation", func(t *testing.T) {
+		f, failing, succeeding := newCanaryFleet()
+		var err error
+		stdout := captureStdout(t, func() {
+			err = command.RunDocumentWithCanary(f, 2, "AWS-RunShellScript", map[string]string{"commands": "uptime"}, 5, nil, strings.NewReader("y\n"))
+		})
+		assert.Nil(t, err)
+		assert.Contains(t, stdout, "Running on canary targets: [i-00000000000000001 i-00000000000000002]")
+		assert.Contains(t, stdout, "1 of 2 canary targets succeeded. Continue with the remaining 1 targets?")
+		assert.Equal(t, 1, strings.Count(stdout, "Use ctrl-c to abort the command early."))
+		assert.Equal(t, []string{"i-00000000000000001"}, commandTargets(failing))
+		assert.ElementsMatch(t, []string{"i-00000000000000002", "i-00000000000000003"}, commandTargets(succeeding))
+	})
+
+	t.Run("Stops when declined", func(t *testing.T) {
+		f, failing, succeeding := newCanaryFleet()
+		var err error
+		captureStdout(t, func() {
+			err = command.RunDocumentWithCanary(f, 2, "AWS-RunShellScript", map[string]string{"commands": "uptime"}, 5, nil, strings.NewReader("\n"))
+		})
+		assert.EqualError(t, err, "stopped after canary")
+		assert.ElementsMatch(t, []string{"i-00000000000000001", "i-00000000000000002"}, commandTargets(failing, succeeding))
+	})
+
+	t.Run("Interrupt at the prompt counts as no", func(t *testing.T) {
+		f, failing, succeeding := newCanaryFleet()
+		abort := make(chan bool)
+		stdin, prompt := io.Pipe()
+		defer stdin.Close()
+		go func() {
+			// The write returns once the prompt is reading stdin.
+			prompt.Write([]byte("y"))
+			abort <- true
+		}()
+		var err error
+		captureStdout(t, func() {
+			err = command.RunDocumentWithCanary(f, 2, "AWS-RunShellScript", map[string]string{"commands": "uptime"}, 5, abort, stdin)
+		})
+		assert.EqualError(t, err, "stopped after canary")
+		assert.ElementsMatch(t, []string{"i-00000000000000001", "i-00000000000000002"}, commandTargets(failing, succeeding))
+	})
+
+	t.Run("Canary covering all targets runs once without asking", func(t *testing.T) {
+		f, failing, succeeding := newCanaryFleet()
+		var err error
+		stdout := captureStdout(t, func() {
+			err = command.RunDocumentWithCanary(f, 3, "AWS-RunShellScript", map[string]string{"commands": "uptime"}, 5, nil, strings.NewReader(""))
+		})
+		assert.Nil(t, err)
+		assert.NotContains(t, stdout, "canary")
+		assert.ElementsMatch(t, []string{"i-00000000000000001", "i-00000000000000002", "i-00000000000000003"}, commandTargets(failing, succeeding))
+	})
+}
+
+func TestRunDocumentTimeout(t *testing.T) {
+	ssmMock, ec2Mock := newTestMocks()
+	ssmMock.CommandStatus = "InProgress"
+	f := command.NewFleet([]*manager.Manager{manager.NewTestManager(ssmMock, nil, ec2Mock)})
+	assert.Nil(t, f.Assign([]string{"i-00000000000000001"}))
+
+	var err error
+	captureStdout(t, func() {
+		err = command.RunDocumentWithCanary(f, 0, "AWS-RunShellScript", map[string]string{"commands": "sleep 60"}, 1, nil, strings.NewReader(""))
+	})
+	assert.EqualError(t, err, "timeout reached")
+	for _, c := range ssmMock.CommandHistory {
+		assert.Equal(t, "Cancelled", c.Status)
+	}
+	assert.Len(t, ssmMock.CommandHistory, 1)
+}
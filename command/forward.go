@@ -0,0 +1,291 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+	"github.com/xtaci/smux"
+)
+
+// Agent versions which multiplex connections over a single port forwarding session,
+// and which no longer expect keep-alives on the multiplexed session.
+const (
+	multiplexingAgentVersion       = "3.0.196.0"
+	multiplexingNoKeepAliveVersion = "3.1.1511.0"
+	portForwardingDocument         = "AWS-StartPortForwardingSession"
+	remotePortForwardingDocument   = "AWS-StartPortForwardingSessionToRemoteHost"
+)
+
+type ForwardCommand struct {
+	Target string `short:"t" long:"target" description:"Instance id or name of the instance to forward through." required:"true"`
+}
+
+func (command *ForwardCommand) Usage() string {
+	return "[forward-OPTIONS] [local_port:]host:port..."
+}
+
+func (command *ForwardCommand) Execute(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected one or more forwards ([local_port:]host:port)")
+	}
+	var forwards []*PortForward
+	for _, arg := range args {
+		f, err := ParsePortForward(arg)
+		if err != nil {
+			return err
+		}
+		forwards = append(forwards, f)
+	}
+
+	managers, err := newManagers(manager.Opts{})
+	if err != nil {
+		return err
+	}
+	m, instance, err := findInstance(managers, command.Target)
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, len(forwards))
+	for _, f := range forwards {
+		listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", f.LocalPort))
+		if err != nil {
+			return errors.Wrapf(err, "failed to listen on port %d", f.LocalPort)
+		}
+		defer listener.Close()
+		fmt.Printf("Forwarding 127.0.0.1:%d to %s:%d through %s\n", f.LocalPort, f.Host, f.RemotePort, instance.ID())
+
+		fw := &forwarder{manager: m, instanceID: instance.InstanceID, forward: f}
+		defer fw.close()
+		go func() {
+			errs <- fw.serve(listener)
+		}()
+	}
+
+	sigs := interruptHandler()
+	select {
+	case err := <-errs:
+		return err
+	case <-sigs:
+		return nil
+	}
+}
+
+// PortForward is a local port which is forwarded to a port on a host reachable from the instance.
+type PortForward struct {
+	LocalPort  int
+	Host       string
+	RemotePort int
+}
+
+// ParsePortForward parses [local_port:]host:port. The local port defaults to the remote port.
+func ParsePortForward(spec string) (*PortForward, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) == 2 {
+		parts = append([]string{parts[1]}, parts...)
+	}
+	if len(parts) != 3 || parts[1] == "" {
+		return nil, errors.Errorf("invalid forward (expected [local_port:]host:port): %s", spec)
+	}
+	local, err := strconv.Atoi(parts[0])
+	if err != nil || local < 1 || local > 65535 {
+		return nil, errors.Errorf("invalid local port: %s", parts[0])
+	}
+	remote, err := strconv.Atoi(parts[2])
+	if err != nil || remote < 1 || remote > 65535 {
+		return nil, errors.Errorf("invalid remote port: %s", parts[2])
+	}
+	return &PortForward{LocalPort: local, Host: parts[1], RemotePort: remote}, nil
+}
+
+// Document returns the session document and parameters used for the forward.
+func (f *PortForward) Document() (string, map[string][]string) {
+	parameters := map[string][]string{
+		"portNumber":      {strconv.Itoa(f.RemotePort)},
+		"localPortNumber": {strconv.Itoa(f.LocalPort)},
+	}
+	if f.Host == "localhost" || f.Host == "127.0.0.1" {
+		return portForwardingDocument, parameters
+	}
+	parameters["host"] = []string{f.Host}
+	return remotePortForwardingDocument, parameters
+}
+
+// forwarder tunnels local connections through port forwarding sessions. Connections are
+// multiplexed over a single session if the agent supports it, otherwise each connection
+// gets a session of its own.
+type forwarder struct {
+	manager    *manager.Manager
+	instanceID string
+	forward    *PortForward
+
+	lock     sync.Mutex
+	mux      *smux.Session
+	starting chan struct{} // Closed when the session being started is ready.
+	single   bool          // The agent does not support multiplexing.
+	closed   bool
+}
+
+func (f *forwarder) serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *forwarder) handle(conn net.Conn) {
+	defer conn.Close()
+	stream, err := f.open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to forward connection from %s: %s\n", conn.RemoteAddr(), err)
+		return
+	}
+	defer stream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(stream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, stream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// Open a stream for a new connection, starting a session if needed. The lock is
+// not held while the session is started, and connections which arrive in the
+// meantime wait for it instead of starting sessions of their own.
+func (f *forwarder) open() (io.ReadWriteCloser, error) {
+	f.lock.Lock()
+	for {
+		if f.closed {
+			f.lock.Unlock()
+			return nil, errors.New("forwarder is closed")
+		}
+		if f.mux != nil && !f.mux.IsClosed() {
+			mux := f.mux
+			f.lock.Unlock()
+			return mux.OpenStream()
+		}
+		if f.starting == nil || f.single {
+			break
+		}
+		starting := f.starting
+		f.lock.Unlock()
+		<-starting
+		f.lock.Lock()
+	}
+	single := f.single
+	starting := make(chan struct{})
+	if !single {
+		f.starting = starting
+	}
+	f.lock.Unlock()
+
+	stream, mux, err := f.start()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !single {
+		f.starting = nil
+		close(starting)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mux == nil {
+		f.single = true
+		return stream, nil
+	}
+	if f.closed {
+		mux.Close()
+		return nil, errors.New("forwarder is closed")
+	}
+	f.mux = mux
+	return mux.OpenStream()
+}
+
+// Start a session, and multiplex it if the agent supports it (mux is nil otherwise).
+func (f *forwarder) start() (io.ReadWriteCloser, *smux.Session, error) {
+	document, parameters := f.forward.Document()
+	pr, pw := io.Pipe()
+	s, err := f.manager.StartSession(f.instanceID, document, parameters, pw)
+	if err != nil {
+		return nil, nil, err
+	}
+	go func() {
+		pw.CloseWithError(s.Wait())
+	}()
+	stream := &sessionStream{Session: s, reader: pr}
+
+	version, err := s.AgentVersion()
+	if err != nil {
+		stream.Close()
+		return nil, nil, err
+	}
+	if compareVersions(version, multiplexingAgentVersion) < 0 {
+		return stream, nil, nil
+	}
+
+	config := smux.DefaultConfig()
+	config.KeepAliveDisabled = compareVersions(version, multiplexingNoKeepAliveVersion) > 0
+	mux, err := smux.Client(stream, config)
+	if err != nil {
+		stream.Close()
+		return nil, nil, errors.Wrap(err, "failed to multiplex session")
+	}
+	return stream, mux, nil
+}
+
+// Close the multiplexed session (if any), which also terminates it.
+func (f *forwarder) close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+	if f.mux != nil {
+		f.mux.Close()
+	}
+}
+
+// sessionStream reads the output of a session from a pipe.
+type sessionStream struct {
+	*manager.Session
+	reader *io.PipeReader
+}
+
+func (s *sessionStream) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+// Compare two dotted version numbers, returning -1, 0 or 1.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,41 @@
+package command_test
+
+import (
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePortForward(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected *command.PortForward
+		document string
+	}{
+		{"5432:localhost:5432", &command.PortForward{LocalPort: 5432, Host: "localhost", RemotePort: 5432}, "AWS-StartPortForwardingSession"},
+		{"localhost:8080", &command.PortForward{LocalPort: 8080, Host: "localhost", RemotePort: 8080}, "AWS-StartPortForwardingSession"},
+		{"15432:db.example.com:5432", &command.PortForward{LocalPort: 15432, Host: "db.example.com", RemotePort: 5432}, "AWS-StartPortForwardingSessionToRemoteHost"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.spec, func(t *testing.T) {
+			actual, err := command.ParsePortForward(tc.spec)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, actual)
+
+			document, parameters := actual.Document()
+			assert.Equal(t, tc.document, document)
+			if tc.expected.Host != "localhost" {
+				assert.Equal(t, []string{tc.expected.Host}, parameters["host"])
+			}
+		})
+	}
+
+	t.Run("Invalid forwards are rejected", func(t *testing.T) {
+		for _, spec := range []string{"5432", "a:localhost:5432", "5432::5432", "5432:localhost:99999", "1:2:3:4"} {
+			_, err := command.ParsePortForward(spec)
+			assert.NotNil(t, err, spec)
+		}
+	})
+}
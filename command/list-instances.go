@@ -14,14 +14,14 @@ type ListInstancesCommand struct {
 	Tags   []*tag `short:"f" long:"filter" description:"Filter the produced list by tag (key=value,..)"`
 	Limit  int64  `short:"l" long:"limit" description:"Limit the number of instances printed" default:"50"`
 	Output string `short:"o" long:"output" description:"Path to a file where the list of instances will be written as JSON."`
+	Where  string `short:"w" long:"where" description:"Only list instances matching an expression (e.g. 'tag:env=prod and ping=Online')."`
 }
 
 func (command *ListInstancesCommand) Execute([]string) error {
-	sess, err := newSession()
+	managers, err := newManagers(manager.Opts{})
 	if err != nil {
-		return errors.Wrap(err, "failed to create new session")
+		return err
 	}
-	m := manager.NewManager(sess, Command.AwsOpts.Region, manager.Opts{})
 
 	var filters []*manager.TagFilter
 	for _, tag := range command.Tags {
@@ -30,9 +30,24 @@ func (command *ListInstancesCommand) Execute([]string) error {
 			Values: tag.Values,
 		})
 	}
-	instances, err := m.ListInstances(command.Limit, filters)
-	if err != nil {
-		return errors.Wrap(err, "failed to list instances")
+	var where *manager.Expression
+	if command.Where != "" {
+		where, err = manager.ParseExpression(command.Where)
+		if err != nil {
+			return errors.Wrap(err, "invalid expression")
+		}
+	}
+
+	var instances []*manager.Instance
+	for _, m := range managers {
+		list, err := m.ListInstancesWhere(command.Limit, filters, where)
+		if err != nil {
+			if id := m.AccountID(); id != "" {
+				return errors.Wrapf(err, "failed to list instances in account %s", id)
+			}
+			return errors.Wrap(err, "failed to list instances")
+		}
+		instances = append(instances, list...)
 	}
 
 	if err := PrintInstances(os.Stdout, instances); err != nil {
@@ -0,0 +1,181 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/chzyer/readline"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+)
+
+// Maximum number of matches which are listed at once in the picker.
+const pickerLimit = 20
+
+type pickerCandidate struct {
+	account  *fleetAccount
+	instance *manager.Instance
+	text     string
+}
+
+// Pick targets interactively from the managed instances in the fleet. The
+// list of instances is filtered by typing a (fuzzy) query which is matched
+// against instance ID, name, IP and tags.
+func pickTargets(f *fleet) error {
+	var candidates []*pickerCandidate
+	for _, a := range f.accounts {
+		instances, err := a.Instances()
+		if err != nil {
+			return err
+		}
+		for _, instance := range instances {
+			candidates = append(candidates, &pickerCandidate{
+				account:  a,
+				instance: instance,
+				text:     strings.Join([]string{instance.ID(), instance.Name, instance.IPAddress, tagString(instance.Tags)}, " "),
+			})
+		}
+	}
+	if len(candidates) == 0 {
+		return errors.New("no managed instances to pick from")
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].instance.Name != candidates[j].instance.Name {
+			return candidates[i].instance.Name < candidates[j].instance.Name
+		}
+		return candidates[i].instance.ID() < candidates[j].instance.ID()
+	})
+
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          "\033[31mfilter»\033[0m ",
+		InterruptPrompt: "^C",
+		EOFPrompt:       "^D",
+	})
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+
+	fmt.Fprintf(rl.Stdout(), "No targets set. Type to filter, +N to select, -N to deselect, + or - for all matches and enter when done.\n")
+
+	var query string
+	selected := make(map[*pickerCandidate]bool)
+	for {
+		var matches []*pickerCandidate
+		for _, c := range candidates {
+			if FuzzyMatch(query, c.text) {
+				matches = append(matches, c)
+			}
+		}
+		printCandidates(rl.Stdout(), matches, selected)
+
+		line, err := rl.Readline()
+		if err == readline.ErrInterrupt || err == io.EOF {
+			return errors.New("no targets selected")
+		} else if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "":
+			if len(selected) > 0 {
+				for _, c := range candidates {
+					if selected[c] {
+						c.account.add(c.instance.ID())
+					}
+				}
+				return nil
+			}
+			fmt.Fprintf(rl.Stdout(), "Nothing selected yet.\n")
+		case line == "+" || line == "-":
+			for _, c := range matches {
+				selected[c] = line == "+"
+				if !selected[c] {
+					delete(selected, c)
+				}
+			}
+		case line[0] == '+' || line[0] == '-':
+			numbers, err := parseNumbers(line[1:], len(matches))
+			if err != nil {
+				fmt.Fprintf(rl.Stdout(), "%s\n", err)
+				continue
+			}
+			for _, n := range numbers {
+				if line[0] == '+' {
+					selected[matches[n-1]] = true
+				} else {
+					delete(selected, matches[n-1])
+				}
+			}
+		default:
+			query = line
+		}
+	}
+}
+
+func printCandidates(wrt io.Writer, matches []*pickerCandidate, selected map[*pickerCandidate]bool) {
+	fmt.Fprintln(wrt)
+	for i, c := range matches {
+		if i == pickerLimit {
+			fmt.Fprintf(wrt, "    ... and %d more, refine the filter to see them.\n", len(matches)-pickerLimit)
+			break
+		}
+		mark := " "
+		if selected[c] {
+			mark = "x"
+		}
+		fmt.Fprintf(wrt, "%3d [%s] %s\n", i+1, mark, c.text)
+	}
+	fmt.Fprintf(wrt, "%d match(es), %d selected.\n", len(matches), len(selected))
+}
+
+// Parse a list of numbers (separated by spaces or commas) between 1 and max.
+func parseNumbers(input string, max int) ([]int, error) {
+	var out []int
+	fields := strings.FieldsFunc(input, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	for _, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 || n > max || n > pickerLimit {
+			return nil, errors.Errorf("invalid selection: %s", field)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// Format tags as a sorted list of key=value pairs.
+func tagString(tags map[string]string) string {
+	var pairs []string
+	for k, v := range tags {
+		if k == "Name" {
+			continue
+		}
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// FuzzyMatch returns true if every word in the query is found in the text as
+// a (case insensitive) subsequence, i.e. "wb1" matches "web-1".
+func FuzzyMatch(query, text string) bool {
+	text = strings.ToLower(text)
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		rest := text
+		for _, r := range word {
+			i := strings.IndexRune(rest, r)
+			if i < 0 {
+				return false
+			}
+			rest = rest[i+len(string(r)):]
+		}
+	}
+	return true
+}
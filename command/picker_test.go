@@ -0,0 +1,34 @@
+package command_test
+
+import (
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	text := "i-00000000000000001 web-1 10.0.0.1 env=prod,role=web"
+
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"", true},
+		{"web", true},
+		{"WEB", true},
+		{"wb1", true},
+		{"10.0.0.1", true},
+		{"prod web", true},
+		{"env=prod", true},
+		{"db-1", false},
+		{"web dev", false},
+		{"staging", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			assert.Equal(t, tc.expected, command.FuzzyMatch(tc.query, text))
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package command
+
+import (
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+)
+
+type ProxyCommand struct{}
+
+func (command *ProxyCommand) Usage() string {
+	return "host port"
+}
+
+func (command *ProxyCommand) Execute(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected a host (instance id or name) and a port")
+	}
+	host, port := args[0], args[1]
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return errors.Errorf("invalid port: %s", port)
+	}
+
+	managers, err := newManagers(manager.Opts{})
+	if err != nil {
+		return err
+	}
+	m, instance, err := findInstance(managers, host)
+	if err != nil {
+		return err
+	}
+
+	return proxy(m, instance.InstanceID, port, os.Stdin, os.Stdout)
+}
+
+// Proxy stdin and stdout to a port on the instance, until stdin is closed or
+// the session ends.
+func proxy(m *manager.Manager, instanceID, port string, stdin io.Reader, stdout io.Writer) error {
+	s, err := m.StartSession(instanceID, "AWS-StartSSHSession", map[string][]string{"portNumber": {port}}, stdout)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// Stdin is closed when the SSH client is done with the connection.
+	go func() {
+		io.Copy(s, stdin)
+		s.Close()
+	}()
+	return s.Wait()
+}
@@ -0,0 +1,74 @@
+package command_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxy(t *testing.T) {
+	// The agent echoes the first input, and closes the channel.
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer conn.Close()
+		send := func(messageType string, sequence int64, payloadType uint32, payload []byte) {
+			b, err := manager.NewClientMessage(messageType, sequence, payloadType, payload).MarshalBinary()
+			assert.Nil(t, err)
+			assert.Nil(t, conn.WriteMessage(websocket.BinaryMessage, b))
+		}
+
+		var open map[string]string
+		assert.Nil(t, conn.ReadJSON(&open))
+		send(manager.OutputStreamMessage, 0, manager.PayloadHandshakeRequest, []byte(`{"AgentVersion":"3.1.1732.0","RequestedClientActions":[]}`))
+		for {
+			_, b, err := conn.ReadMessage()
+			if !assert.Nil(t, err) {
+				return
+			}
+			msg := &manager.ClientMessage{}
+			assert.Nil(t, msg.UnmarshalBinary(b))
+			if msg.MessageType != manager.InputStreamMessage {
+				continue
+			}
+			ack, _ := json.Marshal(map[string]interface{}{
+				"AcknowledgedMessageType":           msg.MessageType,
+				"AcknowledgedMessageId":             msg.MessageID,
+				"AcknowledgedMessageSequenceNumber": msg.SequenceNumber,
+				"IsSequentialMessage":               true,
+			})
+			send(manager.AcknowledgeMessage, 0, 0, ack)
+			if msg.PayloadType == manager.PayloadOutput {
+				send(manager.OutputStreamMessage, 1, manager.PayloadOutput, msg.Payload)
+				break
+			}
+		}
+		closed, _ := json.Marshal(map[string]string{"MessageType": "channel_closed", "Output": "Exiting session"})
+		send(manager.ChannelClosedMessage, 2, 0, closed)
+	}))
+	defer server.Close()
+
+	mock := &manager.MockSSM{SessionURL: "ws" + strings.TrimPrefix(server.URL, "http")}
+	m := manager.NewTestManager(mock, nil, nil)
+
+	stdin, input := io.Pipe()
+	defer input.Close()
+	go input.Write([]byte("SSH-2.0-OpenSSH_7.4\r\n"))
+
+	var stdout bytes.Buffer
+	assert.Nil(t, command.Proxy(m, "i-00000000000000001", "22", stdin, &stdout))
+	assert.Equal(t, "SSH-2.0-OpenSSH_7.4\r\n", stdout.String())
+	assert.Equal(t, []string{"session-i-00000000000000001"}, mock.TerminatedSessions)
+}
@@ -0,0 +1,78 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+type ReplayCommand struct {
+	Timeout    int        `short:"i" long:"timeout" description:"Seconds to wait for each command before timing out (0 to wait forever)." default:"60"`
+	Continue   bool       `long:"continue-on-error" description:"Keep running the commands when one fails on an instance."`
+	Record     string     `long:"record" description:"Append a transcript of the re-executed commands to a file (JSON lines)."`
+	SSMOpts    SSMOptions `group:"SSM options"`
+	TargetOpts TargetOptions
+}
+
+func (command *ReplayCommand) Usage() string {
+	return "[replay-OPTIONS] transcript"
+}
+
+func (command *ReplayCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected the path to a transcript")
+	}
+	r, err := os.Open(args[0])
+	if err != nil {
+		return errors.Wrap(err, "failed to open transcript")
+	}
+	defer r.Close()
+	entries, err := ReadTranscript(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to read transcript")
+	}
+
+	// Render the transcript unless there are targets to re-execute it on.
+	if command.TargetOpts.isEmpty() {
+		return PrintTranscript(os.Stdout, entries)
+	}
+
+	opts, err := command.SSMOpts.Parse()
+	if err != nil {
+		return err
+	}
+	managers, err := newManagers(*opts)
+	if err != nil {
+		return err
+	}
+	f, err := setTargets(managers, command.TargetOpts, "Linux")
+	if err != nil {
+		return errors.Wrap(err, "failed to set targets")
+	}
+	sh, err := newShell(f, command.Timeout, command.Record)
+	if err != nil {
+		return err
+	}
+	defer sh.close()
+
+	var failures int
+	for i, entry := range entries {
+		sh.document = entry.Document
+		fmt.Printf("%s%s\n", sh.prompt(), entry.Command)
+		sh.failed = false
+		if err := sh.execute(entry.Command, sh.format); err != nil {
+			return err
+		}
+		if sh.failed {
+			if !command.Continue {
+				return errors.Errorf("command %d failed: %s", i+1, entry.Command)
+			}
+			failures++
+		}
+	}
+	if failures > 0 {
+		return errors.Errorf("%d command(s) failed", failures)
+	}
+	return nil
+}
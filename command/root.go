@@ -6,8 +6,14 @@ type RootCommand struct {
 	Version  func()          `short:"v" long:"version" description:"Print the version and exit."`
 	List     ListCommand     `command:"list" alias:"ls" description:"List managed instances or documents."`
 	Shell    ShellCommand    `command:"shell" alias:"sh" description:"Start an interactive shell."`
+	Session  SessionCommand  `command:"session" description:"Start an interactive Session Manager session on an instance."`
+	Forward  ForwardCommand  `command:"forward" description:"Forward local ports through an instance."`
+	Cp       CpCommand       `command:"cp" description:"Copy files to or from the targeted instances."`
+	Proxy    ProxyCommand    `command:"proxy" description:"Connect stdin/stdout to SSH on an instance (for use as an OpenSSH ProxyCommand)."`
 	Run      RunCommand      `command:"run" description:"Run a command or document on the targeted instances."`
+	Replay   ReplayCommand   `command:"replay" description:"Print a transcript recorded by the shell, or run it again on new targets."`
 	Describe DescribeCommand `command:"describe" description:"Description a document from ssm."`
+	Document DocumentCommand `command:"document" alias:"doc" description:"Create, update, delete or download documents."`
 	AwsOpts  AwsOptions      `group:"AWS Options"`
 }
 
@@ -25,12 +31,29 @@ type DescribeCommand struct {
 	Describe DescribeDocumentCommand `command:"document" alias:"doc" description:"Description a document from ssm."`
 }
 
+type DocumentCommand struct {
+	Push   DocumentPushCommand   `command:"push" description:"Create a document from a local file, or add a new version if it exists."`
+	Pull   DocumentPullCommand   `command:"pull" description:"Write the content of a document to a local file."`
+	Delete DocumentDeleteCommand `command:"delete" description:"Delete a document and all its versions."`
+}
+
 type AwsOptions struct {
-	Profile string `short:"p" long:"profile" description:"AWS Profile to use. (If you are not using Vaulted)."`
-	Region  string `short:"r" long:"region" description:"Region to target."`
+	Profile      string   `short:"p" long:"profile" description:"AWS Profile to use. (If you are not using Vaulted)."`
+	Region       string   `short:"r" long:"region" description:"Region to target."`
+	RoleArns     []string `long:"role-arn" description:"One or more IAM roles to assume. Each role targets the account it belongs to."`
+	AccountsFile string   `long:"accounts-file" description:"Path to a JSON file containing a list of roles to assume."`
+	ExternalID   string   `long:"external-id" description:"External ID to use when assuming roles."`
+	SessionName  string   `long:"role-session-name" description:"Session name to use when assuming roles." default:"ssm-sh"`
 }
 
 type TargetOptions struct {
-	Targets    []string `short:"t" long:"target" description:"One or more instance ids to target"`
-	TargetFile string   `long:"target-file" description:"Path to a JSON file containing a list of targets."`
+	Targets           []string `short:"t" long:"target" description:"One or more instance ids, names or patterns (e.g. web-* or re:^db-[0-9]+$) to target"`
+	TargetFile        string   `long:"target-file" description:"Path to a file (JSON, YAML, CSV or one id per line) containing a list of targets. Use - to read from stdin."`
+	AutoScalingGroups []string `long:"target-asg" description:"Target the managed instances in one or more Auto Scaling groups."`
+	ResourceGroups    []string `long:"target-resource-group" description:"Target the managed instances in one or more resource groups."`
+	Where             string   `short:"w" long:"where" description:"Target instances matching an expression (e.g. 'tag:env=prod and not name=bastion-*')."`
+	Excludes          []string `long:"exclude" description:"One or more instance ids, names, patterns or tag filters (key=value,..) to exclude from the targets."`
+	SkipOffline       bool     `long:"skip-offline" description:"Skip targets where the SSM agent is not online (ConnectionLost or Inactive)."`
+	Sample            string   `long:"sample" description:"Target a random sample of the instances, either a number (5) or a percentage (10%)."`
+	Seed              *int64   `long:"seed" description:"Seed used to choose the sample. Use the same seed to get the same sample."`
 }
@@ -0,0 +1,103 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+)
+
+type SessionCommand struct {
+	Target   string `short:"t" long:"target" description:"Instance id or name of the instance to start a session on." required:"true"`
+	Document string `long:"document" description:"Session document to use (defaults to a standard shell session)."`
+}
+
+func (command *SessionCommand) Execute(args []string) error {
+	managers, err := newManagers(manager.Opts{})
+	if err != nil {
+		return err
+	}
+	m, instance, err := findInstance(managers, command.Target)
+	if err != nil {
+		return err
+	}
+
+	s, err := m.StartSession(instance.InstanceID, command.Document, nil, os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	fmt.Fprintf(os.Stderr, "Starting session %s on %s\n", s.ID, instance.ID())
+
+	return attachTerminal(s)
+}
+
+// Find a single managed instance by id or name across all accounts. Names are compared
+// case-insensitively since OpenSSH lowercases host names.
+func findInstance(managers []*manager.Manager, target string) (*manager.Manager, *manager.Instance, error) {
+	var found *manager.Instance
+	var owner *manager.Manager
+	var count int
+	for _, m := range managers {
+		instances, err := m.ListInstances(50, nil)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to list instances")
+		}
+		for _, instance := range instances {
+			if instance.InstanceID == target || strings.EqualFold(instance.Name, target) {
+				found, owner = instance, m
+				count++
+			}
+		}
+	}
+	switch count {
+	case 0:
+		return nil, nil, errors.Errorf("no managed instance matches: %s", target)
+	case 1:
+		return owner, found, nil
+	}
+	return nil, nil, errors.Errorf("%s matches %d instances, use an instance id instead", target, count)
+}
+
+// Connect the terminal to a session until it ends. If stdin is a terminal it is put in
+// raw mode so that keys like Ctrl-C are sent to the instance.
+func attachTerminal(s *manager.Session) error {
+	stdin := int(os.Stdin.Fd())
+	if readline.IsTerminal(stdin) {
+		state, err := readline.MakeRaw(stdin)
+		if err != nil {
+			return errors.Wrap(err, "failed to set terminal in raw mode")
+		}
+		defer readline.Restore(stdin, state)
+		go watchTerminalSize(s, int(os.Stdout.Fd()))
+	}
+	go io.Copy(s, os.Stdin)
+	return s.Wait()
+}
+
+// Poll the terminal size and resize the session when it changes. Polling is used
+// instead of SIGWINCH, which is not available on Windows.
+func watchTerminalSize(s *manager.Session, fd int) {
+	var cols, rows int
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		w, h, err := readline.GetSize(fd)
+		if err == nil && (w != cols || h != rows) {
+			cols, rows = w, h
+			if err := s.Resize(cols, rows); err != nil {
+				return
+			}
+		}
+		select {
+		case <-s.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
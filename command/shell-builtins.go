@@ -0,0 +1,290 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+)
+
+var builtinPattern = regexp.MustCompile(`^:[a-z]+$`)
+
+// shellBuiltin is a meta-command in the shell, which is prefixed with ":".
+type shellBuiltin struct {
+	name  string
+	usage string
+	help  string
+	run   func(sh *shell, args []string) error
+}
+
+// The built-in commands, in the order they are listed by :help.
+func shellBuiltins() []*shellBuiltin {
+	return []*shellBuiltin{
+		{name: ":targets", usage: "[add|remove <filter>...]", help: "List, add or remove targets (ids, names, patterns or key=value,.. tag filters).", run: builtinTargets},
+		{name: ":timeout", usage: "[seconds]", help: "Show or set the timeout for commands (0 to disable).", run: builtinTimeout},
+		{name: ":doc", usage: "[name]", help: "Show or set the document used to run commands (e.g. AWS-RunPowerShellScript).", run: builtinDoc},
+		{name: ":format", usage: "[full|collapse]", help: "Show or set the output format. Collapse groups instances with identical output.", run: builtinFormat},
+		{name: ":last", help: "Print the output from the last command again.", run: builtinLast},
+		{name: ":save", usage: "<file>", help: "Save the output from the last command to a file (as JSON if the file ends with .json).", run: builtinSave},
+		{name: ":jobs", help: "List the commands running in the background (started with a trailing &).", run: builtinJobs},
+		{name: ":fg", usage: "[job]", help: "Wait for a background job and print its output.", run: builtinFg},
+		{name: ":kill", usage: "[job]", help: "Abort a background job.", run: builtinKill},
+		{name: ":history", usage: "[n]", help: "List the history, or run entry n again on the current targets.", run: builtinHistory},
+		{name: ":help", help: "Show this help.", run: builtinHelp},
+	}
+}
+
+// Look up the built-in for a line. Lines which are not built-ins (including
+// shell commands starting with ":", like ": > file") return nil.
+func (sh *shell) lookupBuiltin(line string) (*shellBuiltin, []string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !builtinPattern.MatchString(fields[0]) {
+		return nil, nil
+	}
+	for _, b := range shellBuiltins() {
+		if b.name == fields[0] {
+			return b, fields[1:]
+		}
+	}
+	return &shellBuiltin{name: fields[0], run: func(*shell, []string) error {
+		return errors.New("unknown command, type :help for a list of built-in commands")
+	}}, nil
+}
+
+func builtinTargets(sh *shell, args []string) error {
+	if len(args) == 0 {
+		return printTargets(sh.fleet)
+	}
+	if len(args) < 2 {
+		return errors.New("expected add or remove followed by one or more filters")
+	}
+
+	previous := make(map[string]bool)
+	for _, target := range sh.fleet.Targets() {
+		previous[target] = true
+	}
+	for _, filter := range args[1:] {
+		match, err := compileFilter(filter)
+		if err != nil {
+			return err
+		}
+		switch args[0] {
+		case "add":
+			n, err := sh.fleet.Include(match)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return errors.Errorf("%s did not match any instances", filter)
+			}
+			fmt.Printf("Target %s matched %d instance(s)\n", filter, n)
+		case "remove":
+			n, err := sh.fleet.Remove(match)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Removed %d target(s) matching %s\n", n, filter)
+		default:
+			return errors.Errorf("unknown subcommand: %s", args[0])
+		}
+	}
+	if args[0] == "add" {
+		if err := sh.preflight(previous); err != nil {
+			return err
+		}
+	}
+	return printTargets(sh.fleet)
+}
+
+// Check the status of the targets which are not in previous with Preflight, and
+// remove the ones which it skips.
+func (sh *shell) preflight(previous map[string]bool) error {
+	added := sh.fleet.Filter(func(target string) bool {
+		return !previous[target]
+	})
+	if len(added.Targets()) == 0 {
+		return nil
+	}
+	var platform string
+	if sh.document == shellDocument {
+		platform = "Linux"
+	}
+	err := added.Preflight(platform, sh.skipOffline)
+
+	kept := make(map[string]bool)
+	for _, target := range added.Targets() {
+		kept[target] = true
+	}
+	if _, removeErr := sh.fleet.Remove(func(instance *manager.Instance) bool {
+		return !previous[instance.ID()] && !kept[instance.ID()]
+	}); removeErr != nil {
+		return removeErr
+	}
+	return err
+}
+
+// Print the targets along with their names.
+func printTargets(f *fleet) error {
+	var lines []string
+	for _, a := range f.accounts {
+		instances, err := a.Instances()
+		if err != nil {
+			return err
+		}
+		names := make(map[string]string)
+		for _, instance := range instances {
+			names[instance.ID()] = instance.Name
+		}
+		for _, target := range a.targets {
+			id := target
+			if account := a.manager.AccountID(); account != "" {
+				id = account + "/" + target
+			}
+			lines = append(lines, fmt.Sprintf("  %s\t%s", id, names[target]))
+		}
+	}
+	fmt.Printf("%d target(s):\n", len(lines))
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func builtinTimeout(sh *shell, args []string) error {
+	if len(args) == 0 {
+		if sh.timeout == 0 {
+			fmt.Println("No timeout")
+		} else {
+			fmt.Printf("Timeout: %d seconds\n", sh.timeout)
+		}
+		return nil
+	}
+	seconds, err := strconv.Atoi(args[0])
+	if err != nil || seconds < 0 {
+		return errors.Errorf("invalid timeout: %s", args[0])
+	}
+	sh.timeout = seconds
+	return nil
+}
+
+func builtinDoc(sh *shell, args []string) error {
+	if len(args) == 0 {
+		fmt.Printf("Document: %s\n", sh.document)
+		return nil
+	}
+	sh.document = args[0]
+	if sh.document != shellDocument {
+		fmt.Printf("Note: the working directory and environment are only kept for %s.\n", shellDocument)
+	}
+	sh.updatePrompt()
+	return nil
+}
+
+func builtinFormat(sh *shell, args []string) error {
+	if len(args) == 0 {
+		fmt.Printf("Format: %s\n", sh.format)
+		return nil
+	}
+	switch args[0] {
+	case "full", "collapse":
+		sh.format = args[0]
+		return nil
+	}
+	return errors.Errorf("unknown format: %s", args[0])
+}
+
+func builtinLast(sh *shell, args []string) error {
+	if sh.last == nil {
+		return errors.New("no commands have been run")
+	}
+	return printOutputs(os.Stdout, sh.last, sh.format)
+}
+
+func builtinSave(sh *shell, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a file name")
+	}
+	if sh.last == nil {
+		return errors.New("no commands have been run")
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(args[0]), ".json") {
+		err = writeOutputs(f, sh.last)
+	} else {
+		noColor := color.NoColor
+		color.NoColor = true
+		err = printOutputs(f, sh.last, sh.format)
+		color.NoColor = noColor
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved output from %d instance(s) to %s\n", len(sh.last), args[0])
+	return nil
+}
+
+func builtinHistory(sh *shell, args []string) error {
+	if len(args) == 0 {
+		for i, line := range sh.history {
+			fmt.Printf("%5d  %s\n", i+1, line)
+		}
+		return nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(sh.history) {
+		return errors.Errorf("no such entry: %s", args[0])
+	}
+	line := sh.history[n-1]
+	if strings.HasPrefix(line, ":history") {
+		return errors.New("cannot run :history again")
+	}
+	fmt.Println(line)
+	sh.remember(line)
+	return sh.handle(line)
+}
+
+func builtinHelp(sh *shell, args []string) error {
+	fmt.Println("Built-in commands:")
+	for _, b := range shellBuiltins() {
+		fmt.Printf("  %-32s %s\n", strings.TrimSpace(b.name+" "+b.usage), b.help)
+	}
+	fmt.Println("  exit                             Exit the shell.")
+	return nil
+}
+
+// Print outputs in the given format (full or collapse).
+func printOutputs(wrt io.Writer, outputs []*manager.CommandOutput, format string) error {
+	if format == "collapse" {
+		return PrintCollapsedOutput(wrt, outputs)
+	}
+	for _, output := range outputs {
+		if err := PrintCommandOutput(wrt, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write outputs as JSON.
+func writeOutputs(wrt io.Writer, outputs []*manager.CommandOutput) error {
+	var records []*OutputRecord
+	for _, output := range outputs {
+		records = append(records, NewOutputRecord(output))
+	}
+	enc := json.NewEncoder(wrt)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
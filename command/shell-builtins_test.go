@@ -0,0 +1,121 @@
+package command_test
+
+import (
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupBuiltin(t *testing.T) {
+	tests := []struct {
+		description string
+		line        string
+		name        string
+		args        []string
+	}{
+		{description: "Empty line", line: ""},
+		{description: "Blank line", line: "   "},
+		{description: "Command", line: "uptime"},
+		{description: "Colon command", line: ": > /tmp/file"},
+		{description: "Colon without space", line: ":>/tmp/file"},
+		{description: "Without arguments", line: ":targets", name: ":targets", args: []string{}},
+		{description: "With arguments", line: "  :targets add web-*  env=prod ", name: ":targets", args: []string{"add", "web-*", "env=prod"}},
+		{description: "Unknown", line: ":foo bar", name: ":foo"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			name, args := command.LookupBuiltin(tc.line)
+			assert.Equal(t, tc.name, name)
+			assert.Equal(t, tc.args, args)
+		})
+	}
+}
+
+func TestBuiltinTargets(t *testing.T) {
+	f := command.NewFleet([]*manager.Manager{newTestManager("")})
+	sh := command.NewTestShell(f, 0)
+	defer sh.Close()
+
+	tests := []struct {
+		line     string
+		expected []string
+		failed   bool
+	}{
+		{line: ":targets", expected: nil},
+		{line: ":targets add web-*", expected: []string{"i-00000000000000001", "i-00000000000000002"}},
+		{line: ":targets add env=prod", expected: []string{"i-00000000000000001", "i-00000000000000002", "i-00000000000000003"}},
+		{line: ":targets remove web-2 i-00000000000000003", expected: []string{"i-00000000000000001"}},
+		{line: ":targets remove cache-*", expected: []string{"i-00000000000000001"}},
+		{line: ":targets add cache-*", expected: []string{"i-00000000000000001"}, failed: true},
+		{line: ":targets add", expected: []string{"i-00000000000000001"}, failed: true},
+		{line: ":targets drop web-1", expected: []string{"i-00000000000000001"}, failed: true},
+		{line: ":targets add re:web-(", expected: []string{"i-00000000000000001"}, failed: true},
+		{line: ":foo", expected: []string{"i-00000000000000001"}, failed: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.line, func(t *testing.T) {
+			sh.SetFailed(false)
+			assert.Nil(t, sh.Handle(tc.line))
+			assert.Equal(t, tc.failed, sh.Failed())
+			assert.ElementsMatch(t, tc.expected, sh.Targets())
+		})
+	}
+}
+
+func TestBuiltinTargetsPreflight(t *testing.T) {
+	newShell := func(skipOffline bool) *command.Shell {
+		f := command.NewFleet([]*manager.Manager{newTestManager("")})
+		assert.Nil(t, f.Assign([]string{"i-00000000000000001"}))
+		sh := command.NewTestShell(f, 0)
+		sh.SetSkipOffline(skipOffline)
+		return sh
+	}
+
+	t.Run("Offline targets are added with a warning", func(t *testing.T) {
+		sh := newShell(false)
+		defer sh.Close()
+		stdout := captureStdout(t, func() {
+			assert.Nil(t, sh.Handle(":targets add db-*"))
+		})
+		assert.False(t, sh.Failed())
+		assert.Contains(t, stdout, "Warning: i-00000000000000004 is ConnectionLost")
+		assert.ElementsMatch(t, []string{"i-00000000000000001", "i-00000000000000003", "i-00000000000000004"}, sh.Targets())
+	})
+
+	t.Run("Offline targets are skipped", func(t *testing.T) {
+		sh := newShell(true)
+		defer sh.Close()
+		stdout := captureStdout(t, func() {
+			assert.Nil(t, sh.Handle(":targets add db-*"))
+		})
+		assert.False(t, sh.Failed())
+		assert.Contains(t, stdout, "Skipping i-00000000000000004: ConnectionLost")
+		assert.ElementsMatch(t, []string{"i-00000000000000001", "i-00000000000000003"}, sh.Targets())
+	})
+
+	t.Run("Adding only offline targets fails", func(t *testing.T) {
+		sh := newShell(true)
+		defer sh.Close()
+		captureStdout(t, func() {
+			assert.Nil(t, sh.Handle(":targets add db-2"))
+		})
+		assert.True(t, sh.Failed())
+		assert.Equal(t, []string{"i-00000000000000001"}, sh.Targets())
+	})
+
+	t.Run("Existing targets are not checked again", func(t *testing.T) {
+		sh := newShell(false)
+		defer sh.Close()
+		captureStdout(t, func() {
+			assert.Nil(t, sh.Handle(":targets add db-2"))
+		})
+		stdout := captureStdout(t, func() {
+			assert.Nil(t, sh.Handle(":targets add web-*"))
+		})
+		assert.NotContains(t, stdout, "Warning")
+	})
+}
@@ -0,0 +1,271 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itsdalmo/ssm-sh/manager"
+)
+
+const (
+	// Seconds to wait for a remote listing before completing nothing. The
+	// listing continues in the background, and is used when tab is pressed again.
+	remoteCompletionWait = 1
+	// Seconds before a remote listing is aborted.
+	remoteCompletionTimeout = 5
+)
+
+// ShellCompleter is the tab completion for the shell. It completes built-ins,
+// instances for :targets, local paths for built-ins which write files and
+// remote paths for everything else. Paths are listed by directory, and the
+// listing functions are given the directory as typed (empty for the working
+// directory).
+type ShellCompleter struct {
+	Instances   func() []string
+	LocalFiles  func(dir string) []string
+	RemoteFiles func(dir string) []string
+}
+
+// Do implements readline.AutoCompleter.
+func (c *ShellCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	text := string(line[:pos])
+	words := strings.Fields(text)
+	var word string
+	if len(words) > 0 && !strings.HasSuffix(text, " ") {
+		word, words = words[len(words)-1], words[:len(words)-1]
+	}
+
+	if len(words) == 0 {
+		if !strings.HasPrefix(word, ":") {
+			return nil, 0
+		}
+		var names []string
+		for _, b := range shellBuiltins() {
+			names = append(names, b.name)
+		}
+		return completeWord(names, word)
+	}
+
+	switch words[0] {
+	case ":save":
+		if len(words) == 1 {
+			return completePath(c.LocalFiles, word)
+		}
+	case ":targets":
+		if len(words) == 1 {
+			return completeWord([]string{"add", "remove"}, word)
+		}
+		return completeWord(c.Instances(), word)
+	case ":format":
+		if len(words) == 1 {
+			return completeWord([]string{"full", "collapse"}, word)
+		}
+	default:
+		if !strings.HasPrefix(words[0], ":") {
+			return completePath(c.RemoteFiles, word)
+		}
+	}
+	return nil, 0
+}
+
+// Complete a word from a list of options.
+func completeWord(options []string, word string) ([][]rune, int) {
+	var candidates [][]rune
+	for _, option := range options {
+		if strings.HasPrefix(option, word) {
+			candidates = append(candidates, []rune(option[len(word):]+" "))
+		}
+	}
+	return candidates, len([]rune(word))
+}
+
+// Complete a path from the files in its directory. Directories end with "/",
+// and hidden files are only completed when the name starts with ".".
+func completePath(list func(dir string) []string, word string) ([][]rune, int) {
+	i := strings.LastIndex(word, "/")
+	dir, prefix := word[:i+1], word[i+1:]
+
+	var candidates [][]rune
+	for _, name := range list(dir) {
+		if !strings.HasPrefix(name, prefix) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		candidate := name[len(prefix):]
+		if !strings.HasSuffix(name, "/") {
+			candidate += " "
+		}
+		candidates = append(candidates, []rune(candidate))
+	}
+	return candidates, len([]rune(prefix))
+}
+
+// List a local directory for completion.
+func localFiles(dir string) []string {
+	path := dir
+	if path == "" {
+		path = "."
+	} else if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, path[2:])
+	}
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+		files = append(files, name)
+	}
+	return files
+}
+
+// List the IDs and names of the instances in the fleet, using the cached
+// result from ListInstances.
+func (sh *shell) instanceNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, a := range sh.fleet.accounts {
+		instances, err := a.Instances()
+		if err != nil {
+			continue
+		}
+		for _, instance := range instances {
+			for _, name := range []string{instance.ID(), instance.Name} {
+				if name != "" && !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List a remote directory for completion by running ls on the first target.
+// Listings are cached until the next command is run. Tab only waits briefly
+// for a listing, so that a slow target does not freeze the prompt.
+func (sh *shell) remoteFiles(dir string) []string {
+	if sh.document != shellDocument {
+		return nil
+	}
+	key := sh.state.Dir + "\x00" + dir
+
+	sh.completionMu.Lock()
+	if files, ok := sh.completions[key]; ok {
+		sh.completionMu.Unlock()
+		return files
+	}
+	done, ok := sh.listings[key]
+	if !ok {
+		var account *fleetAccount
+		for _, a := range sh.fleet.accounts {
+			if len(a.targets) > 0 {
+				account = a
+				break
+			}
+		}
+		if account == nil {
+			sh.completionMu.Unlock()
+			return nil
+		}
+
+		path := shellQuote(dir)
+		if dir == "" {
+			path = "."
+		} else if strings.HasPrefix(dir, "~/") {
+			path = "~/" + shellQuote(dir[2:])
+		}
+		script := "ls -1Ap -- " + path
+		if sh.state.Dir != "" {
+			script = "cd " + shellQuote(sh.state.Dir) + " && " + script
+		}
+
+		done = make(chan struct{})
+		if sh.listings == nil {
+			sh.listings = make(map[string]chan struct{})
+		}
+		sh.listings[key] = done
+		go sh.listRemoteFiles(account.manager, account.targets[:1], script, key, done)
+	}
+	sh.completionMu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(remoteCompletionWait * time.Second):
+		return nil
+	}
+	sh.completionMu.Lock()
+	defer sh.completionMu.Unlock()
+	return sh.completions[key]
+}
+
+// Run a listing and cache the result, unless the cache was reset while it ran.
+func (sh *shell) listRemoteFiles(m *manager.Manager, target []string, script, key string, done chan struct{}) {
+	defer close(done)
+	files, ok := listRemoteFiles(m, target, script)
+
+	sh.completionMu.Lock()
+	defer sh.completionMu.Unlock()
+	if sh.listings[key] != done {
+		return
+	}
+	delete(sh.listings, key)
+	if !ok {
+		return
+	}
+	if sh.completions == nil {
+		sh.completions = make(map[string][]string)
+	}
+	sh.completions[key] = files
+}
+
+// Reset the cached listings, since a command might change the remote files.
+func (sh *shell) resetCompletions() {
+	sh.completionMu.Lock()
+	defer sh.completionMu.Unlock()
+	sh.completions = nil
+	sh.listings = nil
+}
+
+// Run a listing script on a target. Returns false if it timed out.
+func listRemoteFiles(m *manager.Manager, target []string, script string) ([]string, bool) {
+	commandID, err := m.RunCommand(target, shellDocument, map[string]string{"commands": script})
+	if err != nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), remoteCompletionTimeout*time.Second)
+	defer cancel()
+
+	out := make(chan *manager.CommandOutput)
+	go m.GetCommandOutput(ctx, target, commandID, out)
+
+	var files []string
+	for output := range out {
+		if output.Error != nil || output.Status != "Success" {
+			continue
+		}
+		for _, line := range strings.Split(output.Output, "\n") {
+			if line != "" {
+				files = append(files, line)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		m.AbortCommand(target, commandID)
+		return nil, false
+	}
+	return files, true
+}
@@ -0,0 +1,135 @@
+package command_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellCompleter(t *testing.T) {
+	var listed []string
+	completer := &command.ShellCompleter{
+		Instances: func() []string {
+			return []string{"i-00000000000000001", "i-00000000000000002", "web-1"}
+		},
+		LocalFiles: func(dir string) []string {
+			listed = append(listed, "local:"+dir)
+			return []string{"output.json", "output.txt", "logs/"}
+		},
+		RemoteFiles: func(dir string) []string {
+			listed = append(listed, "remote:"+dir)
+			return []string{".bashrc", "log/", "lib/", "messages"}
+		},
+	}
+
+	tests := []struct {
+		description string
+		line        string
+		expected    []string
+		length      int
+		listed      []string
+	}{
+		{
+			description: "Completes built-ins",
+			line:        ":t",
+			expected:    []string{"argets ", "imeout "},
+			length:      2,
+		},
+		{
+			description: "Does not complete remote commands",
+			line:        "ec",
+			expected:    nil,
+			length:      0,
+		},
+		{
+			description: "Completes target subcommands",
+			line:        ":targets a",
+			expected:    []string{"dd "},
+			length:      1,
+		},
+		{
+			description: "Completes instances",
+			line:        ":targets add web-1 i-",
+			expected:    []string{"00000000000000001 ", "00000000000000002 "},
+			length:      2,
+		},
+		{
+			description: "Completes local paths",
+			line:        ":save logs/out",
+			expected:    []string{"put.json ", "put.txt "},
+			length:      3,
+			listed:      []string{"local:logs/"},
+		},
+		{
+			description: "Completes remote paths",
+			line:        "tail -f /var/l",
+			expected:    []string{"og/", "ib/"},
+			length:      1,
+			listed:      []string{"remote:/var/"},
+		},
+		{
+			description: "Completes hidden remote paths",
+			line:        "cat .",
+			expected:    []string{"bashrc "},
+			length:      1,
+			listed:      []string{"remote:"},
+		},
+		{
+			description: "Completes empty words",
+			line:        "ls ",
+			expected:    []string{"log/", "lib/", "messages "},
+			length:      0,
+			listed:      []string{"remote:"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			listed = nil
+			line := []rune(tc.line)
+			candidates, length := completer.Do(line, len(line))
+
+			var actual []string
+			for _, c := range candidates {
+				actual = append(actual, string(c))
+			}
+			assert.Equal(t, tc.expected, actual)
+			assert.Equal(t, tc.length, length)
+			assert.Equal(t, tc.listed, listed)
+		})
+	}
+}
+
+func TestRemoteFiles(t *testing.T) {
+	newShell := func(status string) (*command.Shell, *manager.MockSSM) {
+		ssmMock, ec2Mock := newTestMocks()
+		ssmMock.CommandStatus = status
+		f := command.NewFleet([]*manager.Manager{manager.NewTestManager(ssmMock, nil, ec2Mock)})
+		if err := f.Assign([]string{"i-00000000000000001"}); err != nil {
+			t.Fatal(err)
+		}
+		return command.NewTestShell(f, 0), ssmMock
+	}
+
+	t.Run("Listings are cached", func(t *testing.T) {
+		sh, ssmMock := newShell("Success")
+		defer sh.Close()
+
+		assert.Equal(t, []string{"example standard output"}, sh.RemoteFiles(""))
+		assert.Equal(t, []string{"example standard output"}, sh.RemoteFiles(""))
+		assert.Len(t, ssmMock.CommandHistory, 1)
+	})
+
+	t.Run("Slow listings do not block", func(t *testing.T) {
+		sh, _ := newShell("InProgress")
+		defer sh.Close()
+
+		started := time.Now()
+		assert.Nil(t, sh.RemoteFiles(""))
+		assert.Nil(t, sh.RemoteFiles(""))
+		assert.True(t, time.Since(started) < 4*time.Second, "waited %s", time.Since(started))
+	})
+}
@@ -0,0 +1,221 @@
+package command
+
+import (
+	"strings"
+)
+
+// Keywords which open and close compound commands.
+var (
+	openKeywords = map[string]bool{
+		"if": true, "for": true, "while": true, "until": true, "case": true, "select": true, "{": true,
+	}
+	closeKeywords = map[string]bool{
+		"fi": true, "done": true, "esac": true, "}": true,
+	}
+	// Keywords after which the next word is a command.
+	commandKeywords = map[string]bool{
+		"then": true, "do": true, "else": true, "elif": true, "!": true,
+	}
+)
+
+// NeedsContinuation returns true if the input is not a complete shell command
+// and more lines should be read before running it: when a quote or heredoc is
+// not terminated, the last line ends with a backslash or a pipe/and/or, or a
+// compound command (if, for, while, until, case or {) is not closed.
+func NeedsContinuation(input string) bool {
+	continues, _ := scanShell(input)
+	return continues
+}
+
+// Replace the heredoc bodies (and their delimiters) in the input with spaces,
+// so that the commands can be inspected without matching the heredoc contents.
+// The length and line breaks of the input are kept.
+func maskHeredocs(input string) string {
+	_, heredocLines := scanShell(input)
+	lines := strings.Split(input, "\n")
+	for i, line := range lines {
+		if heredocLines[i] {
+			lines[i] = strings.Repeat(" ", len(line))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Scan the input and return true if it needs continuation, along with which of
+// the lines are heredoc bodies or delimiters.
+func scanShell(input string) (bool, []bool) {
+	var (
+		heredocLines []bool
+		quote        rune
+		escaped      bool
+		depth        int
+		heredocs     []heredoc
+		body         []heredoc
+		word         strings.Builder
+		command      = true
+		trailing     string
+		continued    bool
+	)
+
+	// Called at the end of every unquoted word.
+	endWord := func() {
+		if word.Len() == 0 {
+			return
+		}
+		w := word.String()
+		word.Reset()
+		trailing = ""
+		if !command {
+			return
+		}
+		switch {
+		case openKeywords[w]:
+			depth++
+		case closeKeywords[w]:
+			depth--
+		case commandKeywords[w]:
+		default:
+			command = false
+			return
+		}
+		command = !closeKeywords[w]
+	}
+
+	for _, line := range strings.Split(input, "\n") {
+		heredocLines = append(heredocLines, len(body) > 0)
+
+		// Lines in heredocs are only checked for the delimiter.
+		if len(body) > 0 {
+			check := line
+			if body[0].stripTabs {
+				check = strings.TrimLeft(check, "\t")
+			}
+			if check == body[0].delimiter {
+				body = body[1:]
+			}
+			continue
+		}
+
+		runes := []rune(line)
+		for i := 0; i < len(runes); i++ {
+			c := runes[i]
+			switch {
+			case escaped:
+				escaped = false
+				word.WriteRune(c)
+			case quote != 0:
+				if c == '\\' && quote != '\'' {
+					escaped = true
+				} else if c == quote {
+					quote = 0
+				}
+				word.WriteRune(c)
+			case c == '\\':
+				escaped = true
+				word.WriteRune(c)
+			case c == '\'' || c == '"' || c == '`':
+				quote = c
+				word.WriteRune(c)
+			case c == '#' && word.Len() == 0:
+				i = len(runes)
+			case c == '$' && strings.HasPrefix(string(runes[i:]), "$(("):
+				// Arithmetic expansion, which can contain << and parentheses.
+				rest := string(runes[i:])
+				end := len(rest)
+				if n := strings.Index(rest, "))"); n >= 0 {
+					end = n + 2
+				}
+				word.WriteString(rest[:end])
+				i += len([]rune(rest[:end])) - 1
+			case c == '<' && strings.HasPrefix(string(runes[i:]), "<<<"):
+				word.WriteString("<<<")
+				i += 2
+			case c == '<' && strings.HasPrefix(string(runes[i:]), "<<"):
+				endWord()
+				h, n := parseHeredoc(runes[i+2:])
+				if h.delimiter != "" {
+					heredocs = append(heredocs, h)
+				}
+				i += 1 + n
+			case c == ' ' || c == '\t':
+				endWord()
+			case c == ';' || c == '&' || c == '|' || c == '(' || c == ')':
+				endWord()
+				command = true
+				trailing = ""
+				if c == '|' || (c == '&' && i > 0 && runes[i-1] == '&') {
+					trailing = string(c)
+				}
+				if c == ';' && i+1 < len(runes) && runes[i+1] == ';' {
+					i++
+				}
+			default:
+				word.WriteRune(c)
+			}
+		}
+
+		// A newline inside quotes is part of the word.
+		if quote != 0 {
+			word.WriteRune('\n')
+			continue
+		}
+		// A trailing backslash continues the line.
+		if escaped {
+			escaped = false
+			continued = true
+			continue
+		}
+		endWord()
+		command = true
+		// A line ending with a pipe, && or || continues on the next line.
+		continued = trailing != ""
+		body = append(body, heredocs...)
+		heredocs = nil
+	}
+	return quote != 0 || continued || len(body) > 0 || depth > 0, heredocLines
+}
+
+// heredoc is a pending heredoc delimiter.
+type heredoc struct {
+	delimiter string
+	stripTabs bool
+}
+
+// Parse the heredoc delimiter after <<. Returns the number of runes consumed.
+func parseHeredoc(runes []rune) (heredoc, int) {
+	var h heredoc
+	i := 0
+	if i < len(runes) && runes[i] == '-' {
+		h.stripTabs = true
+		i++
+	}
+	for i < len(runes) && (runes[i] == ' ' || runes[i] == '\t') {
+		i++
+	}
+	var delimiter strings.Builder
+	var quote rune
+	for ; i < len(runes); i++ {
+		c := runes[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			} else {
+				delimiter.WriteRune(c)
+			}
+			continue
+		}
+		if c == '\'' || c == '"' {
+			quote = c
+			continue
+		}
+		if c == '\\' {
+			continue
+		}
+		if strings.ContainsRune(" \t;&|()<>", c) {
+			break
+		}
+		delimiter.WriteRune(c)
+	}
+	h.delimiter = delimiter.String()
+	return h, i
+}
@@ -0,0 +1,68 @@
+package command_test
+
+import (
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNeedsContinuation(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		expected    bool
+	}{
+		{description: "Simple command", input: "uptime", expected: false},
+		{description: "Comment", input: "echo hi # if for 'quote", expected: false},
+		{description: "Unterminated single quote", input: "echo 'hello", expected: true},
+		{description: "Terminated single quote", input: "echo 'hello\nworld'", expected: false},
+		{description: "Unterminated double quote", input: `echo "say \"hi`, expected: true},
+		{description: "Trailing backslash", input: "yum install -y \\", expected: true},
+		{description: "Escaped trailing backslash", input: "echo \\\\", expected: false},
+		{description: "Continued line", input: "yum install -y \\\n  git", expected: false},
+		{description: "Trailing pipe", input: "cat /etc/hosts |", expected: true},
+		{description: "Trailing and", input: "cd /tmp &&", expected: true},
+		{description: "Background", input: "sleep 10 &", expected: false},
+		{description: "Open for loop", input: "for i in 1 2 3; do", expected: true},
+		{description: "Closed for loop", input: "for i in 1 2 3; do\n  echo $i\ndone", expected: false},
+		{description: "Keywords as arguments", input: "echo if for done", expected: false},
+		{description: "Open if", input: "if [ -f /etc/hosts ]; then\n  echo yes\nelse", expected: true},
+		{description: "Nested blocks", input: "if true; then\n  while true; do\n    break\n  done\nfi", expected: false},
+		{description: "Open nested blocks", input: "if true; then\n  while true; do\n    break\n  done", expected: true},
+		{description: "Case", input: "case $x in\n  a) echo a;;\n  *) echo b;;\nesac", expected: false},
+		{description: "Open function", input: "f() {", expected: true},
+		{description: "Closed function", input: "f() { echo hi; }", expected: false},
+		{description: "Open heredoc", input: "cat <<EOF > /tmp/file\nhello", expected: true},
+		{description: "Closed heredoc", input: "cat <<EOF > /tmp/file\nhello\nEOF", expected: false},
+		{description: "Quoted heredoc", input: "cat <<'EOF'\nif 'unbalanced\nEOF", expected: false},
+		{description: "Tab stripped heredoc", input: "cat <<-EOF\n\thello\n\tEOF", expected: false},
+		{description: "Here string", input: "grep a <<< 'abc'", expected: false},
+		{description: "Arithmetic shift", input: "echo $((1<<2))", expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, command.NeedsContinuation(tc.input))
+		})
+	}
+}
+
+func TestShellContinues(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		expected    bool
+	}{
+		{description: "Empty line", input: "", expected: false},
+		{description: "Blank line", input: "   ", expected: false},
+		{description: "Built-in", input: ":targets add 'web", expected: false},
+		{description: "Command", input: "echo 'hello", expected: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, command.ShellContinues(tc.input))
+		})
+	}
+}
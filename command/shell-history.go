@@ -0,0 +1,69 @@
+package command
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Number of lines kept in the shell history.
+const historyLimit = 500
+
+// HistoryPath returns the path of the shell history, which is kept in
+// $XDG_STATE_HOME/ssm-sh (~/.local/state/ssm-sh by default). When targets
+// are given the history is specific to that set of targets.
+func HistoryPath(targets []string) (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	name := "history"
+	if len(targets) > 0 {
+		sorted := append([]string(nil), targets...)
+		sort.Strings(sorted)
+		sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+		name += "-" + hex.EncodeToString(sum[:])[:12]
+	}
+	return filepath.Join(dir, "ssm-sh", name), nil
+}
+
+// OpenHistory creates the history file if it does not exist, makes sure it is
+// only readable by the user, and returns the most recent entries.
+func OpenHistory(path string) ([]string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create history directory")
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open history")
+	}
+	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		return nil, errors.Wrap(err, "failed to set history permissions")
+	}
+
+	var history []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			history = append(history, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read history")
+	}
+	if len(history) > historyLimit {
+		history = history[len(history)-historyLimit:]
+	}
+	return history, nil
+}
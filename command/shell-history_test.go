@@ -0,0 +1,71 @@
+package command_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	state := os.Getenv("XDG_STATE_HOME")
+	os.Setenv("XDG_STATE_HOME", dir)
+	defer os.Setenv("XDG_STATE_HOME", state)
+
+	t.Run("Paths", func(t *testing.T) {
+		shared, err := command.HistoryPath(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, filepath.Join(dir, "ssm-sh", "history"), shared)
+
+		a, err := command.HistoryPath([]string{"i-1", "i-2"})
+		assert.Nil(t, err)
+		b, err := command.HistoryPath([]string{"i-2", "i-1"})
+		assert.Nil(t, err)
+		c, err := command.HistoryPath([]string{"i-1"})
+		assert.Nil(t, err)
+		assert.Equal(t, a, b)
+		assert.NotEqual(t, a, c)
+		assert.NotEqual(t, a, shared)
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		path := filepath.Join(dir, "ssm-sh", "history")
+		history, err := command.OpenHistory(path)
+		assert.Nil(t, err)
+		assert.Empty(t, history)
+
+		info, err := os.Stat(path)
+		if assert.Nil(t, err) {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		}
+
+		var lines []string
+		for i := 0; i < 510; i++ {
+			lines = append(lines, fmt.Sprintf("echo %d", i), "")
+		}
+		assert.Nil(t, ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644))
+		assert.Nil(t, os.Chmod(path, 0644))
+
+		history, err = command.OpenHistory(path)
+		assert.Nil(t, err)
+		assert.Len(t, history, 500)
+		assert.Equal(t, "echo 10", history[0])
+		assert.Equal(t, "echo 509", history[499])
+
+		info, err = os.Stat(path)
+		if assert.Nil(t, err) {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+)
+
+// shellJob is a command which runs in the background while the shell keeps
+// reading commands. The output is buffered until it is brought to the foreground.
+type shellJob struct {
+	id       int
+	command  string
+	inv      *invocation
+	started  time.Time
+	cancel   context.CancelFunc
+	done     chan struct{}
+	mu       sync.Mutex
+	outputs  []*manager.CommandOutput
+	finished time.Time
+	status   string // Done, Killed or Timeout once finished.
+}
+
+// Returns the command if the line should run in the background (ends with a
+// single & which is not escaped). Heredoc bodies are ignored, so the & can be
+// on the line which starts the heredoc.
+func backgroundCommand(line string) (string, bool) {
+	masked := strings.TrimRight(maskHeredocs(line), " \t\n")
+	i := len(masked) - 1
+	if i < 0 || masked[i] != '&' || strings.HasSuffix(masked, "&&") || strings.HasSuffix(masked, "\\&") {
+		return "", false
+	}
+	return strings.TrimSpace(line[:i] + line[i+1:]), true
+}
+
+// Start a command in the background. Background jobs run with the current
+// working directory and environment, but changes to them are not kept. Jobs
+// are aborted when the shell timeout is reached.
+func (sh *shell) background(cmd string) error {
+	started := time.Now()
+	inv, script, err := sh.start(cmd)
+	if err != nil || inv == nil {
+		return err
+	}
+
+	sh.lastJob++
+	ctx, cancel := sh.commandContext()
+	job := &shellJob{
+		id:      sh.lastJob,
+		command: cmd,
+		inv:     inv,
+		started: started,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	sh.jobs = append(sh.jobs, job)
+	fmt.Printf("[%d] %s\n", job.id, strings.Join(inv.CommandIDs(), ", "))
+
+	document := sh.document
+	go func() {
+		defer close(job.done)
+		defer cancel()
+		out := make(chan *manager.CommandOutput)
+		go inv.GetCommandOutput(ctx, out)
+		for output := range out {
+			result := *output
+			if document == shellDocument {
+				result.Output, _ = sh.state.Extract(output.Output)
+			}
+			job.mu.Lock()
+			job.outputs = append(job.outputs, &result)
+			job.mu.Unlock()
+		}
+
+		job.mu.Lock()
+		if job.status == "" {
+			job.finished = time.Now()
+			job.status = "Done"
+			if ctx.Err() == context.DeadlineExceeded {
+				job.status = "Timeout"
+			}
+		}
+		status, outputs := job.status, job.outputs
+		job.mu.Unlock()
+
+		// The shell is exiting.
+		if status == "Done" && ctx.Err() != nil {
+			return
+		}
+		if status == "Timeout" {
+			if err := inv.Abort(); err != nil {
+				fmt.Fprintf(sh.stdout(), "[%d] failed to abort command after timeout: %s\n", job.id, err)
+			}
+		}
+		if err := sh.record(cmd, script, document, inv, started, outputs, inv.Pending(outputs)); err != nil {
+			fmt.Fprintf(sh.stdout(), "[%d] %s\n", job.id, err)
+		}
+		if status != "Killed" {
+			fmt.Fprintf(sh.stdout(), "[%d] %-8s%s (use :fg %d to see the output)\n", job.id, status, cmd, job.id)
+		}
+	}()
+	return nil
+}
+
+// Write notifications without garbling the prompt.
+func (sh *shell) stdout() io.Writer {
+	if sh.rl != nil {
+		return sh.rl.Stdout()
+	}
+	return os.Stdout
+}
+
+// Look up a job by number, or the most recent job if no number is given.
+func (sh *shell) lookupJob(args []string) (*shellJob, error) {
+	if len(sh.jobs) == 0 {
+		return nil, errors.New("no jobs")
+	}
+	if len(args) == 0 {
+		return sh.jobs[len(sh.jobs)-1], nil
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "%"))
+	if err != nil {
+		return nil, errors.Errorf("invalid job: %s", args[0])
+	}
+	for _, job := range sh.jobs {
+		if job.id == id {
+			return job, nil
+		}
+	}
+	return nil, errors.Errorf("no such job: %d", id)
+}
+
+// Wait for a job to complete (or for the user to interrupt twice), print its
+// output and remove it from the list of jobs.
+func (sh *shell) foreground(job *shellJob) error {
+	var interrupts int
+Waiting:
+	for {
+		select {
+		case <-job.done:
+			break Waiting
+		case <-sh.abort:
+			interrupts++
+			if interrupts > 1 {
+				fmt.Printf("\nStopped waiting, the job is still in the background.\n")
+				return nil
+			}
+			if err := job.inv.Abort(); err != nil {
+				return errors.Wrap(err, "failed to abort command on sigterm")
+			}
+			fmt.Printf("\nAborting command, press ctrl-c again to stop waiting.\n")
+		}
+	}
+
+	for i, j := range sh.jobs {
+		if j == job {
+			sh.jobs = append(sh.jobs[:i], sh.jobs[i+1:]...)
+			break
+		}
+	}
+	sh.last = job.outputs
+	for _, output := range job.outputs {
+		if output.Status != "Success" {
+			sh.failed = true
+		}
+	}
+	if err := printOutputs(os.Stdout, job.outputs, sh.format); err != nil {
+		return err
+	}
+	if pending := job.inv.Pending(job.outputs); len(pending) > 0 {
+		sh.failed = true
+		printPending(pending)
+	}
+	return nil
+}
+
+// Stop all jobs and the goroutines waiting for them.
+func (sh *shell) stopJobs() {
+	for _, job := range sh.jobs {
+		job.cancel()
+	}
+}
+
+func builtinJobs(sh *shell, args []string) error {
+	for _, job := range sh.jobs {
+		job.mu.Lock()
+		n, finished, status := len(job.outputs), job.finished, job.status
+		job.mu.Unlock()
+
+		if finished.IsZero() {
+			status = fmt.Sprintf("Running (%d/%d done, %s)", n, len(job.inv.Targets()), time.Since(job.started).Round(time.Second))
+		} else {
+			status = fmt.Sprintf("%s (%s)", status, finished.Sub(job.started).Round(time.Second))
+		}
+		fmt.Printf("[%d] %-32s %s\n", job.id, status, job.command)
+	}
+	return nil
+}
+
+func builtinFg(sh *shell, args []string) error {
+	job, err := sh.lookupJob(args)
+	if err != nil {
+		return err
+	}
+	fmt.Println(job.command)
+	return sh.foreground(job)
+}
+
+func builtinKill(sh *shell, args []string) error {
+	job, err := sh.lookupJob(args)
+	if err != nil {
+		return err
+	}
+	job.mu.Lock()
+	running := job.finished.IsZero()
+	job.mu.Unlock()
+	if !running {
+		return errors.Errorf("job %d has already finished", job.id)
+	}
+	if err := job.inv.Abort(); err != nil {
+		return err
+	}
+
+	// Stop waiting for the output, the job is finished once it is aborted.
+	job.mu.Lock()
+	if job.finished.IsZero() {
+		job.finished = time.Now()
+		job.status = "Killed"
+	}
+	job.mu.Unlock()
+	job.cancel()
+	fmt.Printf("[%d] Killed  %s\n", job.id, job.command)
+	return nil
+}
@@ -0,0 +1,76 @@
+package command_test
+
+import (
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackgroundCommand(t *testing.T) {
+	tests := []struct {
+		description string
+		input       string
+		command     string
+		ok          bool
+	}{
+		{description: "Background", input: "sleep 10 &", command: "sleep 10", ok: true},
+		{description: "Without space", input: "sleep 10&", command: "sleep 10", ok: true},
+		{description: "And", input: "make &&", ok: false},
+		{description: "Quoted", input: `echo "&"`, ok: false},
+		{description: "Escaped", input: `echo \&`, ok: false},
+		{description: "Foreground", input: "uptime", ok: false},
+		{description: "Heredoc body ending with &", input: "cat <<EOF\na &\nEOF", ok: false},
+		{description: "Background heredoc", input: "cat <<EOF > f &\na &\nEOF", command: "cat <<EOF > f \na &\nEOF", ok: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			cmd, ok := command.BackgroundCommand(tc.input)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.command, cmd)
+		})
+	}
+}
+
+func TestShellJobs(t *testing.T) {
+	// Commands run until they are cancelled.
+	newShell := func(timeout int) *command.Shell {
+		ssmMock, ec2Mock := newTestMocks()
+		ssmMock.CommandStatus = "InProgress"
+		f := command.NewFleet([]*manager.Manager{manager.NewTestManager(ssmMock, nil, ec2Mock)})
+		if err := f.Assign([]string{"i-00000000000000001", "i-00000000000000002"}); err != nil {
+			t.Fatal(err)
+		}
+		return command.NewTestShell(f, timeout)
+	}
+
+	t.Run("Killed jobs are finished", func(t *testing.T) {
+		sh := newShell(0)
+		defer sh.Close()
+
+		assert.Nil(t, sh.Handle("sleep 60 &"))
+		assert.Nil(t, sh.Handle("sleep 60 &"))
+		assert.Equal(t, []string{"Running", "Running"}, sh.JobStatus(false))
+
+		assert.Nil(t, sh.Handle(":kill 1"))
+		assert.False(t, sh.Failed())
+		assert.Equal(t, []string{"Killed", "Running"}, sh.JobStatus(false))
+
+		assert.Nil(t, sh.Handle(":kill 1"))
+		assert.True(t, sh.Failed())
+	})
+
+	t.Run("Jobs time out", func(t *testing.T) {
+		sh := newShell(1)
+		defer sh.Close()
+
+		assert.Nil(t, sh.Handle("sleep 60 &"))
+		assert.Equal(t, []string{"Timeout"}, sh.JobStatus(true))
+
+		assert.Nil(t, sh.Handle(":fg"))
+		assert.True(t, sh.Failed())
+		assert.Empty(t, sh.JobStatus(false))
+	})
+}
@@ -0,0 +1,111 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SplitLocalPipe splits a line on the first |> which is not quoted or in a
+// heredoc. The part before it runs on the targets, and the output is piped to
+// the part after it, which runs locally.
+func SplitLocalPipe(line string) (remote, local string, ok bool) {
+	var quote rune
+	var escaped bool
+	masked := maskHeredocs(line)
+	for i, c := range masked {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '|' && strings.HasPrefix(masked[i:], "|>"):
+			// The heredoc bodies have to be part of the remote command.
+			if masked[i:] != line[i:] {
+				return "", "", false
+			}
+			return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+2:]), true
+		}
+	}
+	return "", "", false
+}
+
+// Run a command on the targets and pipe the combined output from all
+// instances into a local command.
+func (sh *shell) pipe(remote, local string) error {
+	if remote == "" || local == "" {
+		fmt.Println("Expected a remote and a local command: <remote> |> <local>")
+		sh.failed = true
+		return nil
+	}
+	if _, ok := backgroundCommand(local); ok {
+		fmt.Println("Piped commands cannot run in the background.")
+		sh.failed = true
+		return nil
+	}
+
+	sh.last = nil
+	if err := sh.execute(remote, ""); err != nil {
+		return err
+	}
+	if len(sh.last) == 0 {
+		return nil
+	}
+
+	var input strings.Builder
+	var failed []string
+	for _, output := range sh.last {
+		input.WriteString(output.Output)
+		if output.Output != "" && !strings.HasSuffix(output.Output, "\n") {
+			input.WriteString("\n")
+		}
+		if output.Status != "Success" {
+			failed = append(failed, output.InstanceID)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "Note: the command failed on %s\n", strings.Join(failed, ", "))
+	}
+
+	cmd := exec.Command("sh", "-c", local)
+	cmd.Stdin = strings.NewReader(input.String())
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "failed to run local command")
+	}
+
+	// Stop the local command on interrupt, so that the interrupt is not left
+	// pending for the next remote command.
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var err error
+Waiting:
+	for {
+		select {
+		case <-sh.abort:
+			cmd.Process.Kill()
+		case err = <-done:
+			break Waiting
+		}
+	}
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return errors.Wrap(err, "failed to run local command")
+		}
+		fmt.Printf("Local command failed: %s\n", err)
+		sh.failed = true
+	}
+	return nil
+}
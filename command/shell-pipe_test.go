@@ -0,0 +1,76 @@
+package command_test
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitLocalPipe(t *testing.T) {
+	tests := []struct {
+		input  string
+		remote string
+		local  string
+		ok     bool
+	}{
+		{input: "df -h |> sort -k5", remote: "df -h", local: "sort -k5", ok: true},
+		{input: "cat log|>grep error | wc -l", remote: "cat log", local: "grep error | wc -l", ok: true},
+		{input: "df -h | sort -k5", ok: false},
+		{input: "echo '|>' | cat", ok: false},
+		{input: `echo "a |> b"`, ok: false},
+		{input: `echo \|> cat`, ok: false},
+		{input: `echo "it's" |> cat`, remote: `echo "it's"`, local: "cat", ok: true},
+		{input: "uptime |>", remote: "uptime", local: "", ok: true},
+		{input: "cat <<EOF\na |> b\nEOF", ok: false},
+		{input: "cat <<'EOF' > f\nit's\nEOF\nwc -l f |> cat", remote: "cat <<'EOF' > f\nit's\nEOF\nwc -l f", local: "cat", ok: true},
+		{input: "cat <<EOF |> sort\nb\nEOF", ok: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			remote, local, ok := command.SplitLocalPipe(tc.input)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.remote, remote)
+			assert.Equal(t, tc.local, local)
+		})
+	}
+}
+
+func TestShellPipe(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	f := command.NewFleet([]*manager.Manager{newTestManager("")})
+	if err := f.Assign([]string{"i-00000000000000001"}); err != nil {
+		t.Fatal(err)
+	}
+	sh := command.NewTestShell(f, 0)
+	defer sh.Close()
+	abort := make(chan bool)
+	sh.SetAbort(abort)
+
+	t.Run("Interrupts are passed to the local command", func(t *testing.T) {
+		go func() {
+			// Wait for the remote command to finish before interrupting.
+			time.Sleep(1500 * time.Millisecond)
+			abort <- true
+		}()
+		started := time.Now()
+		assert.Nil(t, sh.Handle("uptime |> sleep 5"))
+		assert.True(t, time.Since(started) < 4*time.Second, "waited %s", time.Since(started))
+		assert.True(t, sh.Failed())
+	})
+
+	t.Run("Next command is not aborted", func(t *testing.T) {
+		sh.SetFailed(false)
+		assert.Nil(t, sh.Handle("uptime"))
+		assert.False(t, sh.Failed())
+		if assert.Len(t, sh.Last(), 1) {
+			assert.Equal(t, "Success", sh.Last()[0].Status)
+		}
+	})
+}
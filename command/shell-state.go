@@ -0,0 +1,143 @@
+package command
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Replaces newlines in environment values, so that each variable is on one line.
+const envNewline = "\x01"
+
+// Environment variables which change on their own and should not be carried over.
+var volatileEnv = map[string]bool{
+	"PWD":    true,
+	"OLDPWD": true,
+	"SHLVL":  true,
+	"_":      true,
+}
+
+// ShellState is the working directory and exported variables which are carried over
+// between commands in the shell, since every command runs as a separate invocation.
+type ShellState struct {
+	Dir    string
+	Env    map[string]string
+	marker string
+}
+
+// ShellUpdate is the working directory and environment changes after a command.
+type ShellUpdate struct {
+	Dir   string
+	Set   map[string]string
+	Unset []string
+}
+
+// NewShellState returns an empty state with a random marker, which is used to
+// separate the state from the command output.
+func NewShellState() *ShellState {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &ShellState{
+		Env:    make(map[string]string),
+		marker: "__ssm_sh_state_" + hex.EncodeToString(b),
+	}
+}
+
+// Wrap a command so that it runs with the current state, and reports the working
+// directory and environment changes after it has run. The state is written to
+// stdout if the command succeeds, and to stderr (which is shown instead of stdout
+// for failed commands) otherwise. The environment is listed with one variable per
+// line, where newlines in values are replaced by envNewline. The command is not
+// run if the working directory no longer exists.
+func (s *ShellState) Wrap(cmd string) string {
+	var b strings.Builder
+	if s.Dir != "" {
+		dir := shellQuote(s.Dir)
+		fmt.Fprintf(&b, "cd %s || { printf 'ssm-sh: failed to change directory to %%s\\n' %s >&2; exit 1; }\n", dir, dir)
+	}
+	for _, k := range s.sortedEnv() {
+		fmt.Fprintf(&b, "export %s=%s\n", k, shellQuote(s.Env[k]))
+	}
+	b.WriteString("__ssm_sh_before=$(mktemp)\n")
+	b.WriteString("__ssm_sh_env() { env -0 | tr '\\n\\0' '\\001\\n'; }\n")
+	b.WriteString("__ssm_sh_env >\"$__ssm_sh_before\"\n")
+	b.WriteString(cmd + "\n")
+	b.WriteString("__ssm_sh_status=$?\n")
+	b.WriteString("__ssm_sh_after=$(mktemp)\n")
+	b.WriteString("__ssm_sh_env >\"$__ssm_sh_after\"\n")
+	b.WriteString("__ssm_sh_state() {\n")
+	fmt.Fprintf(&b, "  printf '\\n%s\\n'\n", s.marker)
+	b.WriteString("  pwd\n")
+	fmt.Fprintf(&b, "  echo %s.env\n", s.marker)
+	b.WriteString("  grep -vxF -f \"$__ssm_sh_before\" \"$__ssm_sh_after\"\n")
+	fmt.Fprintf(&b, "  echo %s.unset\n", s.marker)
+	b.WriteString("  grep -vxF -f \"$__ssm_sh_after\" \"$__ssm_sh_before\" | cut -d= -f1\n")
+	b.WriteString("}\n")
+	b.WriteString("if [ \"$__ssm_sh_status\" -eq 0 ]; then __ssm_sh_state; else __ssm_sh_state >&2; fi\n")
+	b.WriteString("rm -f \"$__ssm_sh_before\" \"$__ssm_sh_after\"\n")
+	b.WriteString("exit $__ssm_sh_status\n")
+	return b.String()
+}
+
+// Extract the state from the output of a wrapped command. Returns the output without
+// the state, and nil if the output did not contain it (e.g. if the command called exit).
+func (s *ShellState) Extract(output string) (string, *ShellUpdate) {
+	i := strings.LastIndex(output, "\n"+s.marker+"\n")
+	if i < 0 {
+		return output, nil
+	}
+	cleaned, state := output[:i], output[i+len(s.marker)+2:]
+
+	update := &ShellUpdate{Set: make(map[string]string)}
+	section := "dir"
+	for _, line := range strings.Split(state, "\n") {
+		switch line {
+		case s.marker + ".env":
+			section = "env"
+			continue
+		case s.marker + ".unset":
+			section = "unset"
+			continue
+		}
+		switch section {
+		case "dir":
+			if update.Dir == "" {
+				update.Dir = line
+			}
+		case "env":
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) == 2 && !volatileEnv[parts[0]] {
+				update.Set[parts[0]] = strings.Replace(parts[1], envNewline, "\n", -1)
+			}
+		case "unset":
+			if _, ok := update.Set[line]; line != "" && !ok && !volatileEnv[line] {
+				update.Unset = append(update.Unset, line)
+			}
+		}
+	}
+	return cleaned, update
+}
+
+// Apply an update to the state.
+func (s *ShellState) Apply(update *ShellUpdate) {
+	if update.Dir != "" {
+		s.Dir = update.Dir
+	}
+	for k, v := range update.Set {
+		s.Env[k] = v
+	}
+	for _, k := range update.Unset {
+		delete(s.Env, k)
+	}
+}
+
+func (s *ShellState) sortedEnv() []string {
+	var keys []string
+	for k := range s.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+package command_test
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellState(t *testing.T) {
+	for _, tool := range []string{"sh", "env", "tr", "mktemp", "grep", "cut"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not available", tool)
+		}
+	}
+	state := command.NewShellState()
+
+	// Run a wrapped command like AWS-RunShellScript does, where stderr is
+	// shown instead of stdout when the command fails.
+	run := func(cmd string) string {
+		c := exec.Command("sh", "-c", state.Wrap(cmd))
+		stdout, err := c.Output()
+		output := string(stdout)
+		if exit, ok := err.(*exec.ExitError); ok {
+			output = string(exit.Stderr)
+		}
+		cleaned, update := state.Extract(output)
+		if assert.NotNil(t, update, output) {
+			state.Apply(update)
+		}
+		return cleaned
+	}
+
+	assert.Equal(t, "", run("cd /tmp && export SSM_SH_TEST=foo && SSM_SH_LOCAL=bar"))
+	assert.Equal(t, "/tmp", state.Dir)
+	assert.Equal(t, "foo", state.Env["SSM_SH_TEST"])
+	assert.NotContains(t, state.Env, "SSM_SH_LOCAL")
+	assert.NotContains(t, state.Env, "PWD")
+
+	assert.Equal(t, "/tmp foo\n", run(`echo "$(pwd) $SSM_SH_TEST"`))
+
+	t.Run("State is kept when a command fails", func(t *testing.T) {
+		assert.Equal(t, "failed\n", run("cd / && echo failed >&2 && false"))
+		assert.Equal(t, "/", state.Dir)
+	})
+
+	t.Run("Unset variables are removed", func(t *testing.T) {
+		run("unset SSM_SH_TEST")
+		assert.NotContains(t, state.Env, "SSM_SH_TEST")
+	})
+
+	t.Run("Values with newlines are kept", func(t *testing.T) {
+		run("export SSM_SH_LINES=\"$(printf 'a\\nSSM_SH_FAKE=b')\"")
+		assert.Equal(t, "a\nSSM_SH_FAKE=b", state.Env["SSM_SH_LINES"])
+		assert.NotContains(t, state.Env, "SSM_SH_FAKE")
+		assert.Equal(t, "a\nSSM_SH_FAKE=b\n", run(`echo "$SSM_SH_LINES"`))
+	})
+
+	t.Run("Command is not run if the directory is missing", func(t *testing.T) {
+		missing := command.NewShellState()
+		missing.Dir = "/ssm-sh/missing"
+		stdout, err := exec.Command("sh", "-c", missing.Wrap("echo ran")).Output()
+		if assert.IsType(t, &exec.ExitError{}, err) {
+			assert.Contains(t, string(err.(*exec.ExitError).Stderr), "failed to change directory to /ssm-sh/missing")
+		}
+		assert.Equal(t, "", string(stdout))
+	})
+
+	t.Run("Missing directory is not expanded in the error", func(t *testing.T) {
+		missing := command.NewShellState()
+		missing.Dir = "/ssm-sh/$(echo x) `echo y` \"z\" 'w' %s\\n"
+		_, err := exec.Command("sh", "-c", missing.Wrap("echo ran")).Output()
+		if assert.IsType(t, &exec.ExitError{}, err) {
+			assert.Contains(t, string(err.(*exec.ExitError).Stderr), "failed to change directory to "+missing.Dir+"\n")
+		}
+	})
+
+	t.Run("Output without state is returned as is", func(t *testing.T) {
+		output, update := state.Extract("hello\n")
+		assert.Equal(t, "hello\n", output)
+		assert.Nil(t, update)
+	})
+}
@@ -1,101 +1,495 @@
 package command
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/chzyer/readline"
 	"github.com/itsdalmo/ssm-sh/manager"
 	"github.com/pkg/errors"
 )
 
+const shellDocument = "AWS-RunShellScript"
+
+// Prompt shown while reading the rest of a multi-line command.
+const continuationPrompt = "\033[31m>\033[0m "
+
 type ShellCommand struct {
+	Timeout    int        `short:"i" long:"timeout" description:"Seconds to wait for each command before timing out (0 to wait forever)." default:"60"`
+	History    bool       `long:"target-history" description:"Keep a separate history for this set of targets."`
+	Script     string     `long:"script" description:"Run the commands in a file (or piped on stdin) instead of reading them interactively."`
+	Continue   bool       `long:"continue-on-error" description:"Keep running the script when a command fails on an instance."`
+	Record     string     `long:"record" description:"Append a transcript of the commands, targets and outputs to a file (JSON lines)."`
 	SSMOpts    SSMOptions `group:"SSM options"`
 	TargetOpts TargetOptions
 }
 
 func (command *ShellCommand) Execute([]string) error {
-	sess, err := newSession()
+	script, err := scriptPath(command.Script, command.TargetOpts.TargetFile, os.Stdin)
 	if err != nil {
-		return errors.Wrap(err, "failed to create new aws session")
+		return err
 	}
 
 	opts, err := command.SSMOpts.Parse()
 	if err != nil {
 		return err
 	}
-	m := manager.NewManager(sess, Command.AwsOpts.Region, *opts)
-	targets, err := setTargets(command.TargetOpts)
+	managers, err := newManagers(*opts)
+	if err != nil {
+		return err
+	}
+	f, err := setTargets(managers, command.TargetOpts, "Linux")
 	if err != nil {
 		return errors.Wrap(err, "failed to set targets")
 	}
-	fmt.Printf("Type 'exit' to exit. Use ctrl-c to abort running commands.\n\n")
 
-	// (Parent) Context for the main thread and output channel
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	sh, err := newShell(f, command.Timeout, command.Record)
+	if err != nil {
+		return err
+	}
+	defer sh.close()
+	sh.skipOffline = command.TargetOpts.SkipOffline
 
-	// Catch sigterms to gracefully shut down
-	var interrupts int
-	abort := interruptHandler()
+	if script != "" {
+		r := os.Stdin
+		if script != "-" {
+			if r, err = os.Open(script); err != nil {
+				return errors.Wrap(err, "failed to open script")
+			}
+			defer r.Close()
+		}
+		return sh.runScript(r, command.Continue)
+	}
+	fmt.Printf("Type 'exit' to exit, or ':help' for built-in commands. Use ctrl-c to abort running commands, and ctrl-c again to stop waiting for them.\n\n")
 
 	// Configure readline
-	rl, err := readline.NewEx(&readline.Config{
-		Prompt:          "\033[31m»\033[0m ",
-		HistoryFile:     "/tmp/ssh-sh.tmp",
-		InterruptPrompt: "^C",
-		EOFPrompt:       "^D",
+	var targets []string
+	if command.History {
+		targets = f.Targets()
+	}
+	historyFile, err := HistoryPath(targets)
+	if err == nil {
+		sh.history, err = OpenHistory(historyFile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: history is disabled: %s\n", err)
+		historyFile = ""
+	}
+	sh.rl, err = readline.NewEx(&readline.Config{
+		Prompt:            sh.prompt(),
+		HistoryFile:       historyFile,
+		HistoryLimit:      historyLimit,
+		HistorySearchFold: true,
+		AutoComplete: &ShellCompleter{
+			Instances:   sh.instanceNames,
+			LocalFiles:  localFiles,
+			RemoteFiles: sh.remoteFiles,
+		},
+		DisableAutoSaveHistory: true,
+		InterruptPrompt:        "^C",
+		EOFPrompt:              "^D",
 	})
 	if err != nil {
 		panic(err)
 	}
-	defer rl.Close()
+	defer sh.rl.Close()
+
+	return sh.run()
+}
+
+// Returns the path of the script to run, where - is stdin. Commands are read
+// from stdin when no script is given and stdin is not a terminal, and an empty
+// path means that the shell is interactive.
+func scriptPath(script, targetFile string, stdin *os.File) (string, error) {
+	if script != "" || readline.IsTerminal(int(stdin.Fd())) {
+		return script, nil
+	}
+	if targetFile == "-" {
+		return "", errors.New("cannot read both the targets and the script from stdin")
+	}
+	return "-", nil
+}
+
+// Create a shell for the fleet, which records a transcript to the given path (if set).
+func newShell(f *fleet, timeout int, record string) (*shell, error) {
+	sh := &shell{
+		fleet:    f,
+		state:    NewShellState(),
+		document: shellDocument,
+		timeout:  timeout,
+		format:   "full",
+		abort:    interruptHandler(),
+	}
+	if record != "" {
+		t, err := OpenTranscript(record)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open transcript")
+		}
+		sh.transcript = t
+	}
+	return sh, nil
+}
+
+// shell is an interactive shell where each line is run as a command on the targets.
+type shell struct {
+	fleet    *fleet
+	state    *ShellState
+	document string
+	timeout  int
+	format   string
+	last     []*manager.CommandOutput
+	history  []string
+	rl       *readline.Instance
+	abort    <-chan bool
+	failed   bool
+
+	// Skip offline targets when they are added with :targets add.
+	skipOffline bool
+
+	// Commands running in the background.
+	jobs    []*shellJob
+	lastJob int
+
+	// Transcript of the commands, if recording.
+	transcript *Transcript
 
+	// Cached remote listings for tab completion, and the listings which are running.
+	completionMu sync.Mutex
+	completions  map[string][]string
+	listings     map[string]chan struct{}
+}
+
+func (sh *shell) close() {
+	sh.stopJobs()
+	if sh.transcript != nil {
+		sh.transcript.Close()
+	}
+}
+
+func (sh *shell) run() error {
+	var lines []string
 	for {
-		cmd, err := rl.Readline()
+		line, err := sh.rl.Readline()
 
 		if err == readline.ErrInterrupt {
+			if len(lines) > 0 {
+				lines = nil
+				sh.updatePrompt()
+			}
 			continue
 		} else if err == io.EOF {
 			return nil
 		}
 
-		cmd = strings.TrimSpace(cmd)
-		if len(cmd) == 0 {
+		// Keep reading until the command is complete.
+		lines = append(lines, line)
+		input := strings.Join(lines, "\n")
+		if strings.TrimSpace(input) == "" {
+			lines = nil
+			continue
+		}
+		if sh.continues(input) {
+			sh.rl.SetPrompt(continuationPrompt)
 			continue
-		} else if cmd == "exit" {
+		}
+		lines = nil
+		sh.updatePrompt()
+
+		input = strings.TrimSpace(input)
+		if input == "exit" {
 			return nil
 		}
 
-		// Start command
-		commandID, err := m.RunCommand(targets, "AWS-RunShellScript", map[string]string{"commands": cmd})
-		if err != nil {
-			return errors.Wrap(err, "failed to Run command")
-		}
-		out := make(chan *manager.CommandOutput)
-		go m.GetCommandOutput(ctx, targets, commandID, out)
-
-	Polling:
-		for {
-			select {
-			case <-abort:
-				interrupts++
-				err := m.AbortCommand(targets, commandID)
-				if err != nil {
-					return errors.Wrap(err, "failed to abort command on sigterm")
-				}
-			case output, open := <-out:
-				if output == nil && !open {
-					break Polling
+		// Re-running an entry saves the entry instead.
+		if !strings.HasPrefix(input, ":history ") {
+			sh.remember(input)
+		}
+		if err := sh.handle(input); err != nil {
+			return err
+		}
+	}
+}
+
+// Returns true if more lines should be read before handling the input.
+// Built-ins are always a single line.
+func (sh *shell) continues(input string) bool {
+	if b, _ := sh.lookupBuiltin(input); b != nil {
+		return false
+	}
+	return NeedsContinuation(input)
+}
+
+// Run each line in a script like it was typed in the shell, and stop at the
+// first line which fails on any instance unless told to continue.
+func (sh *shell) runScript(r io.Reader, continueOnError bool) error {
+	var failures int
+	var lines []string
+	var start int
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		if len(lines) == 0 {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			start = n
+		}
+		lines = append(lines, scanner.Text())
+		input := strings.Join(lines, "\n")
+		if sh.continues(input) {
+			continue
+		}
+		lines = nil
+
+		input = strings.TrimSpace(input)
+		if input == "exit" {
+			break
+		}
+		fmt.Printf("%s%s\n", sh.prompt(), input)
+		sh.failed = false
+		if err := sh.handle(input); err != nil {
+			return err
+		}
+		if sh.failed {
+			if !continueOnError {
+				return errors.Errorf("line %d failed: %s", start, input)
+			}
+			failures++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "failed to read script")
+	}
+	if len(lines) > 0 {
+		return errors.Errorf("line %d: unexpected end of script", start)
+	}
+	for len(sh.jobs) > 0 {
+		job := sh.jobs[0]
+		fmt.Printf("Waiting for [%d] %s\n", job.id, job.command)
+		sh.failed = false
+		if err := sh.foreground(job); err != nil {
+			return err
+		}
+		if sh.failed {
+			if !continueOnError {
+				return errors.Errorf("background job failed: %s", job.command)
+			}
+			failures++
+		}
+	}
+	if failures > 0 {
+		return errors.Errorf("%d command(s) failed", failures)
+	}
+	return nil
+}
+
+// Handle a line, either as a built-in or as a command on the targets.
+func (sh *shell) handle(line string) error {
+	if b, args := sh.lookupBuiltin(line); b != nil {
+		if err := b.run(sh, args); err != nil {
+			fmt.Printf("%s: %s\n", b.name, err)
+			sh.failed = true
+		}
+		return nil
+	}
+	if remote, local, ok := SplitLocalPipe(line); ok {
+		return sh.pipe(remote, local)
+	}
+	if cmd, ok := backgroundCommand(line); ok {
+		return sh.background(cmd)
+	}
+	return sh.execute(line, sh.format)
+}
+
+// Add a line to the history. Multi-line commands are only kept for the
+// session, since the history file has one entry per line.
+func (sh *shell) remember(line string) {
+	sh.history = append(sh.history, line)
+	if len(sh.history) > historyLimit {
+		sh.history = sh.history[1:]
+	}
+	if sh.rl != nil && !strings.Contains(line, "\n") {
+		sh.rl.SaveHistory(line)
+	}
+}
+
+// Update the prompt after the state or document has changed.
+func (sh *shell) updatePrompt() {
+	if sh.rl != nil {
+		sh.rl.SetPrompt(sh.prompt())
+	}
+}
+
+// Start a command on the targets, and return the invocation along with the
+// script which was sent. Returns nil if there are no targets.
+func (sh *shell) start(cmd string) (*invocation, string, error) {
+	if len(sh.fleet.Targets()) == 0 {
+		fmt.Println("No targets, use :targets add to add some.")
+		sh.failed = true
+		return nil, "", nil
+	}
+
+	// The command might change the remote files.
+	sh.resetCompletions()
+
+	// Working directory and environment are only tracked for shell scripts.
+	script := cmd
+	if sh.document == shellDocument {
+		script = sh.state.Wrap(cmd)
+	}
+
+	inv, err := sh.fleet.RunCommand(sh.document, map[string]string{"commands": script})
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to Run command")
+	}
+	return inv, script, nil
+}
+
+// Returns a context for waiting on a command, which expires after the
+// timeout (if set).
+func (sh *shell) commandContext() (context.Context, context.CancelFunc) {
+	if sh.timeout > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(sh.timeout)*time.Second)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// Run a command on the targets and print the output in the given format
+// (nothing is printed if the format is empty).
+func (sh *shell) execute(cmd, format string) error {
+	started := time.Now()
+	inv, script, err := sh.start(cmd)
+	if err != nil || inv == nil {
+		return err
+	}
+
+	ctx, cancel := sh.commandContext()
+	defer cancel()
+
+	out := make(chan *manager.CommandOutput)
+	go inv.GetCommandOutput(ctx, out)
+
+	var outputs []*manager.CommandOutput
+	var pending []string
+	var update *ShellUpdate
+	var diverged bool
+	var interrupts int
+
+Polling:
+	for {
+		select {
+		case <-sh.abort:
+			interrupts++
+			if interrupts > 1 {
+				fmt.Printf("\nStopped waiting for the command.\n")
+				sh.failed = true
+				pending = inv.Pending(outputs)
+				printPending(pending)
+				break Polling
+			}
+			if err := inv.Abort(); err != nil {
+				return errors.Wrap(err, "failed to abort command on sigterm")
+			}
+			fmt.Printf("\nAborting command, press ctrl-c again to stop waiting.\n")
+		case <-ctx.Done():
+			if err := inv.Abort(); err != nil {
+				return errors.Wrap(err, "failed to abort command after timeout")
+			}
+			fmt.Printf("\nTimeout reached after %d seconds.\n", sh.timeout)
+			sh.failed = true
+			pending = inv.Pending(outputs)
+			printPending(pending)
+			break Polling
+		case output, open := <-out:
+			if output == nil && !open {
+				break Polling
+			}
+			result := *output
+			if sh.document == shellDocument {
+				cleaned, u := sh.state.Extract(output.Output)
+				if u != nil {
+					if update == nil {
+						update = u
+					} else if u.Dir != update.Dir {
+						diverged = true
+					}
 				}
-				err := PrintCommandOutput(os.Stdout, output)
-				if err != nil {
+				result.Output = cleaned
+			}
+			outputs = append(outputs, &result)
+			if result.Status != "Success" {
+				sh.failed = true
+			}
+			if format == "full" {
+				if err := PrintCommandOutput(os.Stdout, &result); err != nil {
 					return errors.Wrap(err, "failed to print output")
 				}
 			}
 		}
 	}
+
+	sh.last = outputs
+	if err := sh.record(cmd, script, sh.document, inv, started, outputs, pending); err != nil {
+		return err
+	}
+	if format == "collapse" {
+		if err := PrintCollapsedOutput(os.Stdout, outputs); err != nil {
+			return errors.Wrap(err, "failed to print output")
+		}
+	}
+
+	if update != nil {
+		sh.state.Apply(update)
+		if diverged {
+			fmt.Printf("Note: the working directory differs between targets, using %s\n", sh.state.Dir)
+		}
+		sh.updatePrompt()
+	}
+	return nil
+}
+
+// Record a command in the transcript, if recording.
+func (sh *shell) record(cmd, script, document string, inv *invocation, started time.Time, outputs []*manager.CommandOutput, pending []string) error {
+	if sh.transcript == nil {
+		return nil
+	}
+	entry := &TranscriptEntry{
+		Command:    cmd,
+		Script:     script,
+		Document:   document,
+		Targets:    inv.Targets(),
+		CommandIDs: inv.CommandIDs(),
+		Started:    started,
+		Finished:   time.Now(),
+		Pending:    pending,
+	}
+	for _, output := range outputs {
+		entry.Outputs = append(entry.Outputs, NewOutputRecord(output))
+	}
+	if err := sh.transcript.Record(entry); err != nil {
+		return errors.Wrap(err, "failed to record command")
+	}
+	return nil
+}
+
+// Print the instances which did not return any output.
+func printPending(pending []string) {
+	if len(pending) == 0 {
+		return
+	}
+	fmt.Printf("No output from %d instance(s): %s\n", len(pending), strings.Join(pending, ", "))
+}
+
+// The prompt shows the working directory once it is known.
+func (sh *shell) prompt() string {
+	if sh.state.Dir == "" || sh.document != shellDocument {
+		return "\033[31m»\033[0m "
+	}
+	return fmt.Sprintf("\033[34m%s\033[0m \033[31m»\033[0m ", sh.state.Dir)
 }
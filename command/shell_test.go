@@ -0,0 +1,192 @@
+package command_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+// Read the entries in a transcript.
+func readTranscript(t *testing.T, path string) []*command.TranscriptEntry {
+	r, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	entries, err := command.ReadTranscript(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestShellScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Run a script and return the remote commands which ran.
+	runScript := func(t *testing.T, script io.Reader, continueOnError bool) ([]string, error) {
+		f := command.NewFleet([]*manager.Manager{newTestManager("")})
+		if err := f.Assign([]string{"i-00000000000000001"}); err != nil {
+			t.Fatal(err)
+		}
+		record := filepath.Join(dir, strings.Replace(t.Name(), "/", "-", -1)+".jsonl")
+		sh, err := command.NewShell(f, 5, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = sh.RunScript(script, continueOnError)
+		sh.Close()
+
+		var commands []string
+		for _, entry := range readTranscript(t, record) {
+			commands = append(commands, entry.Command)
+		}
+		return commands, err
+	}
+
+	// The built-in fails since the timeout is invalid.
+	failing := "# Comments and blank lines are skipped\n\necho one\n:timeout never\necho two\n"
+
+	t.Run("Stops at the first failure", func(t *testing.T) {
+		commands, err := runScript(t, strings.NewReader(failing), false)
+		assert.EqualError(t, err, "line 4 failed: :timeout never")
+		assert.Equal(t, []string{"echo one"}, commands)
+	})
+
+	t.Run("Continues on error", func(t *testing.T) {
+		commands, err := runScript(t, strings.NewReader(failing), true)
+		assert.EqualError(t, err, "1 command(s) failed")
+		assert.Equal(t, []string{"echo one", "echo two"}, commands)
+	})
+
+	t.Run("Multi-line commands", func(t *testing.T) {
+		commands, err := runScript(t, strings.NewReader("echo a \\\n  b\ncat <<EOF\nx\nEOF\nexit\necho c\n"), false)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"echo a \\\n  b", "cat <<EOF\nx\nEOF"}, commands)
+	})
+
+	t.Run("Unterminated command", func(t *testing.T) {
+		commands, err := runScript(t, strings.NewReader("echo one\nif true; then\n  echo two\n"), false)
+		assert.EqualError(t, err, "line 2: unexpected end of script")
+		assert.Equal(t, []string{"echo one"}, commands)
+	})
+
+	t.Run("Reads the script from stdin when it is not a terminal", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		script, err := command.ScriptPath("", "", r)
+		assert.Nil(t, err)
+		assert.Equal(t, "-", script)
+
+		_, err = command.ScriptPath("", "-", r)
+		assert.EqualError(t, err, "cannot read both the targets and the script from stdin")
+
+		script, err = command.ScriptPath("commands.sh", "-", r)
+		assert.Nil(t, err)
+		assert.Equal(t, "commands.sh", script)
+
+		go func() {
+			w.Write([]byte("echo one\necho two\n"))
+			w.Close()
+		}()
+		commands, err := runScript(t, r, false)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"echo one", "echo two"}, commands)
+	})
+}
+
+func TestShellExecute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Create a shell where commands finish on i-1, and never finish on i-2
+	// (not even when they are cancelled).
+	newShell := func(t *testing.T, timeout int) (*command.Shell, chan bool, string) {
+		finishing, finishingEC2 := newTestMocks()
+		finishing.Instances = finishing.Instances[:1]
+		hanging, hangingEC2 := newTestMocks()
+		hanging.Instances = hanging.Instances[1:2]
+		hanging.CommandStatus = "InProgress"
+		hanging.CancelStatus = "InProgress"
+		f := command.NewFleet([]*manager.Manager{
+			manager.NewTestManager(finishing, nil, finishingEC2, manager.WithAccountID("111111111111")),
+			manager.NewTestManager(hanging, nil, hangingEC2, manager.WithAccountID("222222222222")),
+		})
+		if err := f.Assign([]string{"i-00000000000000001", "i-00000000000000002"}); err != nil {
+			t.Fatal(err)
+		}
+		record := filepath.Join(dir, strings.Replace(t.Name(), "/", "-", -1)+".jsonl")
+		sh, err := command.NewShell(f, timeout, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		abort := make(chan bool)
+		sh.SetAbort(abort)
+		return sh, abort, record
+	}
+
+	outputs := func(entry *command.TranscriptEntry) []string {
+		var instances []string
+		for _, output := range entry.Outputs {
+			instances = append(instances, output.InstanceID)
+		}
+		return instances
+	}
+
+	t.Run("Timeout", func(t *testing.T) {
+		sh, _, record := newShell(t, 1)
+		started := time.Now()
+		assert.Nil(t, sh.Handle("uptime"))
+		sh.Close()
+		assert.True(t, time.Since(started) < 3*time.Second, "waited %s", time.Since(started))
+		assert.True(t, sh.Failed())
+
+		entries := readTranscript(t, record)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, []string{"i-00000000000000001"}, outputs(entries[0]))
+			assert.Equal(t, []string{"222222222222/i-00000000000000002"}, entries[0].Pending)
+		}
+	})
+
+	t.Run("Second interrupt stops waiting", func(t *testing.T) {
+		sh, abort, record := newShell(t, 0)
+		go func() {
+			abort <- true
+			// Wait for the output from i-1 before interrupting again.
+			time.Sleep(time.Second)
+			abort <- true
+		}()
+		started := time.Now()
+		assert.Nil(t, sh.Handle("uptime"))
+		sh.Close()
+		assert.True(t, time.Since(started) < 3*time.Second, "waited %s", time.Since(started))
+		assert.True(t, sh.Failed())
+
+		entries := readTranscript(t, record)
+		if assert.Len(t, entries, 1) {
+			// The first interrupt cancels the command.
+			assert.Equal(t, []string{"i-00000000000000001"}, outputs(entries[0]))
+			assert.Equal(t, "Cancelled", entries[0].Outputs[0].Status)
+			assert.Equal(t, []string{"222222222222/i-00000000000000002"}, entries[0].Pending)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Read targets from a file, or from stdin if the path is "-". The format is
+// determined by the file extension, or from the content for stdin and files
+// with an unknown extension.
+func readTargetFile(path string) ([]string, error) {
+	var rd io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		rd = f
+	}
+
+	var format string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		format = "json"
+	case ".yaml", ".yml":
+		format = "yaml"
+	case ".csv":
+		format = "csv"
+	case ".txt":
+		format = "text"
+	}
+	return ReadTargets(rd, format)
+}
+
+// ReadTargets reads a list of targets in the given format, which is one of:
+//
+//	json: A list of instances (as written by list instances) or instance ids.
+//	yaml: Same as json.
+//	csv:  A header row followed by records, where one column is instanceId.
+//	text: One instance id per line.
+//
+// If the format is empty it is guessed from the content.
+func ReadTargets(rd io.Reader, format string) ([]string, error) {
+	content, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		format = guessTargetFormat(content)
+	}
+
+	switch format {
+	case "json":
+		var list []interface{}
+		if err := json.Unmarshal(content, &list); err != nil {
+			return nil, errors.Wrap(err, "failed to parse json")
+		}
+		return targetsFromList(list)
+	case "yaml":
+		var list []interface{}
+		if err := yaml.Unmarshal(content, &list); err != nil {
+			return nil, errors.Wrap(err, "failed to parse yaml")
+		}
+		return targetsFromList(list)
+	case "csv":
+		return targetsFromCSV(content)
+	case "text":
+		return targetsFromText(content)
+	}
+	return nil, errors.Errorf("unknown target file format: %s", format)
+}
+
+func guessTargetFormat(content []byte) string {
+	trimmed := bytes.TrimSpace(content)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		return "json"
+	case bytes.HasPrefix(trimmed, []byte("---")), bytes.HasPrefix(trimmed, []byte("- ")):
+		return "yaml"
+	}
+	firstLine := strings.SplitN(string(trimmed), "\n", 2)[0]
+	if strings.Contains(firstLine, ",") {
+		return "csv"
+	}
+	return "text"
+}
+
+// Extract instance ids from a decoded list where each element is either an
+// instance id or an object with an instanceId key.
+func targetsFromList(list []interface{}) ([]string, error) {
+	var targets []string
+	for i, item := range list {
+		var id interface{}
+		switch v := item.(type) {
+		case string:
+			id = v
+		case map[string]interface{}:
+			id = v["instanceId"]
+		case map[interface{}]interface{}:
+			id = v["instanceId"]
+		}
+		s, ok := id.(string)
+		if !ok || s == "" {
+			return nil, errors.Errorf("element %d: expected an instance id or an object with an instanceId", i)
+		}
+		targets = append(targets, s)
+	}
+	return targets, nil
+}
+
+func targetsFromCSV(content []byte) ([]string, error) {
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse csv")
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	column := -1
+	for i, name := range records[0] {
+		if strings.EqualFold(strings.TrimSpace(name), "instanceId") {
+			column = i
+		}
+	}
+	if column < 0 {
+		return nil, errors.New("csv header does not contain an instanceId column")
+	}
+
+	var targets []string
+	for i, record := range records[1:] {
+		id := strings.TrimSpace(record[column])
+		if id == "" {
+			return nil, errors.Errorf("record %d: missing instanceId", i+1)
+		}
+		targets = append(targets, id)
+	}
+	return targets, nil
+}
+
+// Read one target per line, ignoring blank lines and comments (#).
+func targetsFromText(content []byte) ([]string, error) {
+	var targets []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	return targets, scanner.Err()
+}
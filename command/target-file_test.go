@@ -0,0 +1,79 @@
+package command_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadTargets(t *testing.T) {
+	expected := []string{"i-00000000000000001", "i-00000000000000002"}
+
+	tests := []struct {
+		description string
+		format      string
+		input       string
+	}{
+		{
+			description: "JSON instances",
+			format:      "json",
+			input:       `[{"instanceId": "i-00000000000000001", "name": "instance 1"}, {"instanceId": "i-00000000000000002"}]`,
+		},
+		{
+			description: "JSON ids",
+			format:      "json",
+			input:       `["i-00000000000000001", "i-00000000000000002"]`,
+		},
+		{
+			description: "YAML",
+			format:      "yaml",
+			input:       "- instanceId: i-00000000000000001\n  name: instance 1\n- i-00000000000000002\n",
+		},
+		{
+			description: "CSV",
+			format:      "csv",
+			input:       "name,instanceId\ninstance 1,i-00000000000000001\ninstance 2,i-00000000000000002\n",
+		},
+		{
+			description: "Text",
+			format:      "text",
+			input:       "# targets\ni-00000000000000001\n\ni-00000000000000002\n",
+		},
+		{
+			description: "Guess JSON",
+			input:       `  ["i-00000000000000001", "i-00000000000000002"]`,
+		},
+		{
+			description: "Guess YAML",
+			input:       "---\n- i-00000000000000001\n- i-00000000000000002\n",
+		},
+		{
+			description: "Guess CSV",
+			input:       "instanceId,name\ni-00000000000000001,instance 1\ni-00000000000000002,instance 2\n",
+		},
+		{
+			description: "Guess text",
+			input:       "i-00000000000000001\ni-00000000000000002",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			actual, err := command.ReadTargets(strings.NewReader(tc.input), tc.format)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, actual)
+		})
+	}
+
+	t.Run("CSV without instanceId fails", func(t *testing.T) {
+		_, err := command.ReadTargets(strings.NewReader("name,id\ninstance 1,i-00000000000000001\n"), "csv")
+		assert.EqualError(t, err, "csv header does not contain an instanceId column")
+	})
+
+	t.Run("Invalid list elements fail", func(t *testing.T) {
+		_, err := command.ReadTargets(strings.NewReader(`[{"name": "instance 1"}]`), "json")
+		assert.EqualError(t, err, "element 0: expected an instance id or an object with an instanceId")
+	})
+}
@@ -0,0 +1,215 @@
+package command
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+)
+
+// Matches the IDs of EC2 instances and on-premise (managed) instances.
+var instanceIDPattern = regexp.MustCompile(`^m?i-[0-9a-f]+$`)
+
+// Set targets, and check their status with Preflight before a sample is drawn
+// (so that skipped targets do not shrink the sample).
+func setTargets(managers []*manager.Manager, options TargetOptions, platform string) (*fleet, error) {
+	var targets []string
+	var patterns []string
+	include := options.Targets
+	if options.TargetFile != "" {
+		list, err := readTargetFile(options.TargetFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read target file")
+		}
+		include = append(list, include...)
+	}
+
+	for _, target := range include {
+		if instanceIDPattern.MatchString(target) {
+			targets = append(targets, target)
+		} else {
+			patterns = append(patterns, target)
+		}
+	}
+
+	f := newFleet(managers)
+	if err := f.Assign(targets); err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range patterns {
+		regex, err := compileTarget(pattern)
+		if err != nil {
+			return nil, err
+		}
+		n, err := f.Match(regex)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, errors.Errorf("target %s did not match any instances", pattern)
+		}
+		fmt.Printf("Target %s matched %d instance(s)\n", pattern, n)
+	}
+
+	for _, name := range options.AutoScalingGroups {
+		n, err := f.Intersect(func(m *manager.Manager) ([]string, error) {
+			return m.AutoScalingGroupInstances(name)
+		})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Auto Scaling group %s matched %d managed instance(s)\n", name, n)
+	}
+
+	for _, name := range options.ResourceGroups {
+		n, err := f.Intersect(func(m *manager.Manager) ([]string, error) {
+			return m.ResourceGroupInstances(name)
+		})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Resource group %s matched %d managed instance(s)\n", name, n)
+	}
+
+	if options.Where != "" {
+		where, err := manager.ParseExpression(options.Where)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid expression")
+		}
+		if err := f.Where(where); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(options.Excludes) > 0 {
+		var excludes []func(*manager.Instance) bool
+		for _, exclude := range options.Excludes {
+			match, err := compileFilter(exclude)
+			if err != nil {
+				return nil, err
+			}
+			excludes = append(excludes, match)
+		}
+		n, err := f.Remove(func(instance *manager.Instance) bool {
+			for _, match := range excludes {
+				if match(instance) {
+					return true
+				}
+			}
+			return false
+		})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Excluded %d target(s)\n", n)
+	}
+
+	targets = f.Targets()
+	if len(targets) == 0 && options.isEmpty() && readline.IsTerminal(int(os.Stdin.Fd())) {
+		if err := pickTargets(f); err != nil {
+			return nil, err
+		}
+		targets = f.Targets()
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no targets set")
+	}
+	if err := f.Preflight(platform, options.SkipOffline); err != nil {
+		return nil, err
+	}
+	targets = f.Targets()
+
+	if options.Sample != "" {
+		n, err := parseSample(options.Sample, len(targets))
+		if err != nil {
+			return nil, err
+		}
+		seed := time.Now().UnixNano()
+		if options.Seed != nil {
+			seed = *options.Seed
+		}
+		f.Sample(n, seed)
+		targets = f.Targets()
+		fmt.Printf("Sampled %d target(s) using seed %d\n", len(targets), seed)
+	}
+
+	fmt.Printf("Initialized with targets: %s\n", targets)
+
+	return f, nil
+
+}
+
+// Returns true if no targets or sources of targets are set.
+func (o TargetOptions) isEmpty() bool {
+	return len(o.Targets) == 0 && o.TargetFile == "" && len(o.AutoScalingGroups) == 0 && len(o.ResourceGroups) == 0 && o.Where == ""
+}
+
+// Compile a target pattern. Patterns prefixed with "re:" are regular
+// expressions, anything else is a glob where * and ? are wildcards.
+func compileTarget(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "re:") {
+		regex, err := regexp.Compile(strings.TrimPrefix(pattern, "re:"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid target pattern: %s", pattern)
+		}
+		return regex, nil
+	}
+	return regexp.MustCompile(manager.GlobPattern(pattern)), nil
+}
+
+// Compile a filter into a function which matches instances. Filters are
+// either tag filters (key=value,..) or instance ids, names and patterns.
+func compileFilter(filter string) (func(*manager.Instance) bool, error) {
+	if parts := strings.SplitN(filter, "=", 2); len(parts) == 2 && !strings.HasPrefix(filter, "re:") {
+		key := parts[0]
+		var values []*regexp.Regexp
+		for _, value := range strings.Split(parts[1], ",") {
+			values = append(values, regexp.MustCompile(manager.GlobPattern(value)))
+		}
+		return func(instance *manager.Instance) bool {
+			tag, ok := instance.Tags[key]
+			if !ok {
+				return false
+			}
+			for _, value := range values {
+				if value.MatchString(tag) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	regex, err := compileTarget(filter)
+	if err != nil {
+		return nil, err
+	}
+	return func(instance *manager.Instance) bool {
+		return regex.MatchString(instance.Name) || regex.MatchString(instance.ID())
+	}, nil
+}
+
+// Parse a sample size which is either a number of targets or a percentage
+// (e.g. 10%) of the total. Percentages are rounded up.
+func parseSample(sample string, total int) (int, error) {
+	if strings.HasSuffix(sample, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(sample, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return 0, errors.Errorf("invalid sample percentage: %s", sample)
+		}
+		return int(math.Ceil(float64(total) * percent / 100)), nil
+	}
+	n, err := strconv.Atoi(sample)
+	if err != nil || n <= 0 {
+		return 0, errors.Errorf("invalid sample size: %s", sample)
+	}
+	return n, nil
+}
@@ -0,0 +1,267 @@
+package command_test
+
+import (
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSample(t *testing.T) {
+	tests := []struct {
+		sample   string
+		expected int
+		err      string
+	}{
+		{sample: "5", expected: 5},
+		{sample: "10%", expected: 2},
+		{sample: "100%", expected: 11},
+		{sample: "0", err: "invalid sample size: 0"},
+		{sample: "150%", err: "invalid sample percentage: 150%"},
+		{sample: "five", err: "invalid sample size: five"},
+		{sample: "%", err: "invalid sample percentage: %"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.sample, func(t *testing.T) {
+			n, err := command.ParseSample(tc.sample, 11)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, n)
+		})
+	}
+}
+
+func TestSetTargetsSample(t *testing.T) {
+	managers := []*manager.Manager{newTestManager("")}
+	all := []string{"i-00000000000000001", "i-00000000000000002", "i-00000000000000003", "i-00000000000000004"}
+
+	t.Run("Offline targets are skipped before sampling", func(t *testing.T) {
+		for seed := int64(0); seed < 10; seed++ {
+			seed := seed
+			f, err := command.SetTargets(managers, command.TargetOptions{
+				Targets:     all,
+				SkipOffline: true,
+				Sample:      "3",
+				Seed:        &seed,
+			}, "Linux")
+			if assert.Nil(t, err) {
+				assert.ElementsMatch(t, all[:3], f.Targets())
+			}
+		}
+	})
+
+	t.Run("Seed 0 gives the same sample", func(t *testing.T) {
+		var samples [][]string
+		for i := 0; i < 2; i++ {
+			seed := int64(0)
+			f, err := command.SetTargets(managers, command.TargetOptions{Targets: all, Sample: "2", Seed: &seed}, "Linux")
+			if assert.Nil(t, err) {
+				samples = append(samples, f.Targets())
+			}
+		}
+		if assert.Len(t, samples, 2) {
+			assert.Equal(t, samples[0], samples[1])
+		}
+	})
+}
+
+func TestSetTargetsExclude(t *testing.T) {
+	managers := []*manager.Manager{newTestManager("")}
+	online := []string{"i-00000000000000001", "i-00000000000000002", "i-00000000000000003"}
+
+	tests := []struct {
+		description string
+		excludes    []string
+		expected    []string
+		err         string
+	}{
+		{description: "By id", excludes: []string{"i-00000000000000002"}, expected: []string{"i-00000000000000001", "i-00000000000000003"}},
+		{description: "By name", excludes: []string{"db-1"}, expected: []string{"i-00000000000000001", "i-00000000000000002"}},
+		{description: "By glob", excludes: []string{"web-*"}, expected: []string{"i-00000000000000003"}},
+		{description: "By tag", excludes: []string{"env=staging"}, expected: []string{"i-00000000000000001", "i-00000000000000003"}},
+		{description: "Multiple", excludes: []string{"web-1", "env=prod"}, expected: []string{"i-00000000000000002"}},
+		{description: "Nothing matches", excludes: []string{"cache-*"}, expected: online},
+		{description: "Malformed filter", excludes: []string{"re:web-("}, err: "invalid target pattern: re:web-(: error parsing regexp: missing closing ): `web-(`"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			f, err := command.SetTargets(managers, command.TargetOptions{Targets: online, Excludes: tc.excludes}, "Linux")
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			if assert.Nil(t, err) {
+				assert.ElementsMatch(t, tc.expected, f.Targets())
+			}
+		})
+	}
+}
+
+func TestFleetRemove(t *testing.T) {
+	f := command.NewFleet([]*manager.Manager{newTestManager("")})
+	assert.Nil(t, f.Assign([]string{"i-00000000000000001", "i-00000000000000002"}))
+
+	n, err := f.Remove(func(instance *manager.Instance) bool {
+		return instance.Name == "db-1"
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = f.Remove(func(instance *manager.Instance) bool {
+		return instance.Name == "web-1"
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"i-00000000000000002"}, f.Targets())
+}
+
+func TestCompileTarget(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		matches   []string
+		unmatched []string
+		err       string
+	}{
+		{pattern: "web-*", matches: []string{"web-", "web-1", "web-prod-1"}, unmatched: []string{"xweb-1", "web"}},
+		{pattern: "db-?", matches: []string{"db-1"}, unmatched: []string{"db-", "db-10"}},
+		{pattern: "i-0abc.1", matches: []string{"i-0abc.1"}, unmatched: []string{"i-0abcx1"}},
+		{pattern: "re:^db-[0-9]+$", matches: []string{"db-1", "db-10"}, unmatched: []string{"db-a", "xdb-1"}},
+		{pattern: "re:web", matches: []string{"web-1", "xweb"}, unmatched: []string{"db-1"}},
+		{pattern: "re:web-(", err: "invalid target pattern: re:web-(: error parsing regexp: missing closing ): `web-(`"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.pattern, func(t *testing.T) {
+			regex, err := command.CompileTarget(tc.pattern)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			if !assert.Nil(t, err) {
+				return
+			}
+			for _, s := range tc.matches {
+				assert.True(t, regex.MatchString(s), s)
+			}
+			for _, s := range tc.unmatched {
+				assert.False(t, regex.MatchString(s), s)
+			}
+		})
+	}
+}
+
+func TestSetTargetsPatterns(t *testing.T) {
+	managers := []*manager.Manager{newTestManager("")}
+
+	tests := []struct {
+		description string
+		targets     []string
+		expected    []string
+		err         string
+	}{
+		{description: "Instance ids", targets: []string{"i-00000000000000002", "i-00000000000000001"}, expected: []string{"i-00000000000000002", "i-00000000000000001"}},
+		{description: "Names", targets: []string{"web-2", "db-1"}, expected: []string{"i-00000000000000002", "i-00000000000000003"}},
+		{description: "Glob", targets: []string{"web-*"}, expected: []string{"i-00000000000000001", "i-00000000000000002"}},
+		{description: "Glob on instance ids", targets: []string{"i-*3"}, expected: []string{"i-00000000000000003"}},
+		{description: "Regular expression", targets: []string{"re:^(web-1|db-1)$"}, expected: []string{"i-00000000000000001", "i-00000000000000003"}},
+		{description: "Overlapping patterns", targets: []string{"web-1", "web-*"}, expected: []string{"i-00000000000000001", "i-00000000000000002"}},
+		{description: "No matches", targets: []string{"web-1", "cache-*"}, err: "target cache-* did not match any instances"},
+		{description: "Invalid expression", targets: []string{"re:("}, err: "invalid target pattern: re:(: error parsing regexp: missing closing ): `(`"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			f, err := command.SetTargets(managers, command.TargetOptions{Targets: tc.targets}, "Linux")
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			if assert.Nil(t, err) {
+				assert.ElementsMatch(t, tc.expected, f.Targets())
+			}
+		})
+	}
+}
+
+func TestSetTargetsPreflight(t *testing.T) {
+	managers := []*manager.Manager{newTestManager("")}
+	all := []string{"i-00000000000000001", "i-00000000000000002", "i-00000000000000003", "i-00000000000000004"}
+
+	tests := []struct {
+		description string
+		targets     []string
+		skipOffline bool
+		platform    string
+		expected    []string
+		output      []string
+		err         string
+	}{
+		{
+			description: "Offline targets are kept with a warning",
+			targets:     all,
+			platform:    "Linux",
+			expected:    all,
+			output:      []string{"Warning: i-00000000000000004 is ConnectionLost"},
+		},
+		{
+			description: "Offline targets are skipped",
+			targets:     all,
+			skipOffline: true,
+			platform:    "Linux",
+			expected:    all[:3],
+			output:      []string{"Skipping i-00000000000000004: ConnectionLost"},
+		},
+		{
+			description: "Targets on another platform are kept with a warning",
+			targets:     all[:2],
+			platform:    "Windows",
+			expected:    all[:2],
+			output: []string{
+				"Warning: i-00000000000000001 is running Linux (Amazon Linux 2)",
+				"Warning: i-00000000000000002 is running Linux (Amazon Linux 2)",
+			},
+		},
+		{
+			description: "Only offline targets",
+			targets:     all[3:],
+			skipOffline: true,
+			platform:    "Linux",
+			err:         "no online targets",
+		},
+		{
+			description: "Unmanaged targets",
+			targets:     []string{"i-00000000000000001", "i-00000000000000009"},
+			platform:    "Linux",
+			err:         "targets are not managed by ssm: [i-00000000000000009]",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			var f *command.Fleet
+			var err error
+			stdout := captureStdout(t, func() {
+				f, err = command.SetTargets(managers, command.TargetOptions{Targets: tc.targets, SkipOffline: tc.skipOffline}, tc.platform)
+			})
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			if assert.Nil(t, err) {
+				assert.ElementsMatch(t, tc.expected, f.Targets())
+			}
+			for _, line := range tc.output {
+				assert.Contains(t, stdout, line)
+			}
+			if tc.platform == "Linux" {
+				assert.NotContains(t, stdout, "is running")
+			}
+		})
+	}
+}
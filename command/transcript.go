@@ -0,0 +1,121 @@
+package command
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/pkg/errors"
+)
+
+// OutputRecord is the JSON representation of a CommandOutput.
+type OutputRecord struct {
+	AccountID  string `json:"accountId,omitempty"`
+	InstanceID string `json:"instanceId"`
+	Status     string `json:"status"`
+	Output     string `json:"output"`
+	OutputURL  string `json:"outputUrl,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// NewOutputRecord converts a CommandOutput to a record.
+func NewOutputRecord(output *manager.CommandOutput) *OutputRecord {
+	r := &OutputRecord{
+		AccountID:  output.AccountID,
+		InstanceID: output.InstanceID,
+		Status:     output.Status,
+		Output:     output.Output,
+		OutputURL:  output.OutputUrl,
+	}
+	if output.Error != nil {
+		r.Error = output.Error.Error()
+	}
+	return r
+}
+
+// CommandOutput converts the record back to a CommandOutput.
+func (r *OutputRecord) CommandOutput() *manager.CommandOutput {
+	output := &manager.CommandOutput{
+		AccountID:  r.AccountID,
+		InstanceID: r.InstanceID,
+		Status:     r.Status,
+		Output:     r.Output,
+		OutputUrl:  r.OutputURL,
+	}
+	if r.Error != "" {
+		output.Error = errors.New(r.Error)
+	}
+	return output
+}
+
+// TranscriptEntry is a command which was run in the shell, along with the output
+// from each instance. Script is the script which was sent to the instances (the
+// command wrapped with the working directory and environment), and Pending are
+// the instances which had not responded when the command timed out or the shell
+// stopped waiting for it.
+type TranscriptEntry struct {
+	Command    string          `json:"command"`
+	Script     string          `json:"script,omitempty"`
+	Document   string          `json:"document"`
+	Targets    []string        `json:"targets"`
+	CommandIDs []string        `json:"commandIds"`
+	Started    time.Time       `json:"started"`
+	Finished   time.Time       `json:"finished"`
+	Outputs    []*OutputRecord `json:"outputs"`
+	Pending    []string        `json:"pending,omitempty"`
+}
+
+// Transcript records the commands run in the shell as JSON lines. It is safe
+// for concurrent use, since background jobs record when they complete.
+type Transcript struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// OpenTranscript opens a transcript for appending, and creates it if it does not
+// exist. The transcript is only readable by the user since it contains the output.
+func OpenTranscript(path string) (*Transcript, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Transcript{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record an entry in the transcript.
+func (t *Transcript) Record(entry *TranscriptEntry) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.enc.Encode(entry)
+}
+
+// Close the transcript.
+func (t *Transcript) Close() error {
+	return t.file.Close()
+}
+
+// ReadTranscript reads the entries in a transcript.
+func ReadTranscript(r io.Reader) ([]*TranscriptEntry, error) {
+	var entries []*TranscriptEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := &TranscriptEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, errors.Wrapf(err, "line %d", n)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
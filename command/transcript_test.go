@@ -0,0 +1,96 @@
+package command_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/itsdalmo/ssm-sh/command"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranscript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssm-sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.jsonl")
+
+	started := time.Date(2018, time.January, 27, 13, 32, 0, 0, time.UTC)
+	entries := []*command.TranscriptEntry{
+		{
+			Command:    "uptime",
+			Script:     "cd /tmp\nuptime",
+			Document:   "AWS-RunShellScript",
+			Targets:    []string{"i-00000000000000001", "i-00000000000000002", "i-00000000000000003"},
+			CommandIDs: []string{"command-1"},
+			Started:    started,
+			Finished:   started.Add(3 * time.Second),
+			Outputs: []*command.OutputRecord{
+				command.NewOutputRecord(&manager.CommandOutput{
+					InstanceID: "i-00000000000000001",
+					Status:     "Success",
+					Output:     "up 1 day",
+				}),
+				command.NewOutputRecord(&manager.CommandOutput{
+					InstanceID: "i-00000000000000002",
+					Status:     "Failed",
+					Output:     "",
+					Error:      errors.New("Unrecoverable status: Failed"),
+				}),
+			},
+			Pending: []string{"i-00000000000000003"},
+		},
+	}
+
+	// Record in two sessions to make sure the transcript is appended to.
+	for _, entry := range []*command.TranscriptEntry{entries[0], entries[0]} {
+		transcript, err := command.OpenTranscript(path)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Nil(t, transcript.Record(entry))
+		assert.Nil(t, transcript.Close())
+	}
+
+	info, err := os.Stat(path)
+	if assert.Nil(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	f, err := os.Open(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer f.Close()
+	actual, err := command.ReadTranscript(f)
+	assert.Nil(t, err)
+	assert.Equal(t, []*command.TranscriptEntry{entries[0], entries[0]}, actual)
+
+	expected := strings.TrimSpace(`
+[2018-01-27T13:32:00Z] » uptime
+Document: AWS-RunShellScript, command ID: command-1, duration: 3s
+Targets: i-00000000000000001, i-00000000000000002, i-00000000000000003
+
+i-00000000000000001 - Success:
+up 1 day
+
+i-00000000000000002 - Failed:
+Unrecoverable status: Failed
+
+No output from 1 instance(s): i-00000000000000003
+`)
+	color.NoColor = true
+	b := new(bytes.Buffer)
+	err = command.PrintTranscript(b, actual[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, expected, strings.TrimSpace(b.String()))
+}
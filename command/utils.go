@@ -1,11 +1,10 @@
 package command
 
 import (
+	"bufio"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/signal"
 	"strings"
@@ -32,40 +31,28 @@ func newSession() (*session.Session, error) {
 	return sess, nil
 }
 
-// Set targets
-func setTargets(options TargetOptions) ([]string, error) {
-	var instances []manager.Instance
-	var targets []string
-	if options.TargetFile != "" {
-		content, err := ioutil.ReadFile(options.TargetFile)
-		if err != nil {
-			return nil, err
-		}
-		if err := json.Unmarshal(content, &instances); err != nil {
-			return nil, err
-		}
-		for _, instance := range instances {
-			targets = append(targets, instance.ID())
-		}
-	}
+// Ask the user a yes/no question on stdin. Defaults to no.
+func confirm(question string) (bool, error) {
+	return confirmFrom(os.Stdin, question)
+}
 
-	for _, target := range options.Targets {
-		targets = append(targets, target)
+// Ask the user a yes/no question, and read the answer from r. Defaults to no.
+func confirmFrom(r io.Reader, question string) (bool, error) {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
 	}
-
-	if len(targets) == 0 {
-		return nil, errors.New("no targets set")
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
 	}
-
-	fmt.Printf("Initialized with targets: %s\n", targets)
-
-	return targets, nil
-
+	return false, nil
 }
 
 func interruptHandler() <-chan bool {
 	abort := make(chan bool)
-	sigterm := make(chan os.Signal)
+	sigterm := make(chan os.Signal, 1)
 	signal.Notify(sigterm, os.Interrupt)
 
 	go func() {
@@ -93,7 +80,11 @@ func interruptHandler() <-chan bool {
 // PrintCommandOutput writes the output from command invocations.
 func PrintCommandOutput(wrt io.Writer, output *manager.CommandOutput) error {
 	header := color.New(color.Bold)
-	if _, err := header.Fprintf(wrt, "\n%s - %s:\n", output.InstanceID, output.Status); err != nil {
+	instance := output.InstanceID
+	if output.AccountID != "" {
+		instance = output.AccountID + "/" + instance
+	}
+	if _, err := header.Fprintf(wrt, "\n%s - %s:\n", instance, output.Status); err != nil {
 		return err
 	}
 	if output.Error != nil {
@@ -112,10 +103,83 @@ func PrintCommandOutput(wrt io.Writer, output *manager.CommandOutput) error {
 	return nil
 }
 
-// PrintInstances writes the output from ListInstances.
+// PrintCollapsedOutput writes the output from a command where instances with
+// identical output are grouped together under a single header.
+func PrintCollapsedOutput(wrt io.Writer, outputs []*manager.CommandOutput) error {
+	type group struct {
+		instances []string
+		output    *manager.CommandOutput
+	}
+	var groups []*group
+	lookup := make(map[string]*group)
+	for _, output := range outputs {
+		key := fmt.Sprintf("%s\x00%s\x00%v\x00%s", output.Status, output.Output, output.Error, output.OutputUrl)
+		g, ok := lookup[key]
+		if !ok {
+			g = &group{output: output}
+			lookup[key] = g
+			groups = append(groups, g)
+		}
+		instance := output.InstanceID
+		if output.AccountID != "" {
+			instance = output.AccountID + "/" + instance
+		}
+		g.instances = append(g.instances, instance)
+	}
+
+	for _, g := range groups {
+		collapsed := *g.output
+		collapsed.AccountID = ""
+		collapsed.InstanceID = strings.Join(g.instances, ", ")
+		if err := PrintCommandOutput(wrt, &collapsed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintTranscript writes the commands in a transcript along with their output.
+func PrintTranscript(wrt io.Writer, entries []*TranscriptEntry) error {
+	header := color.New(color.Bold)
+	for _, entry := range entries {
+		if _, err := header.Fprintf(wrt, "\n[%s] » %s\n", entry.Started.Format(time.RFC3339), entry.Command); err != nil {
+			return err
+		}
+		duration := entry.Finished.Sub(entry.Started).Round(time.Second)
+		if _, err := fmt.Fprintf(wrt, "Document: %s, command ID: %s, duration: %s\nTargets: %s\n",
+			entry.Document, strings.Join(entry.CommandIDs, ", "), duration, strings.Join(entry.Targets, ", ")); err != nil {
+			return err
+		}
+		for _, output := range entry.Outputs {
+			if err := PrintCommandOutput(wrt, output.CommandOutput()); err != nil {
+				return err
+			}
+		}
+		if len(entry.Pending) > 0 {
+			if _, err := fmt.Fprintf(wrt, "No output from %d instance(s): %s\n", len(entry.Pending), strings.Join(entry.Pending, ", ")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PrintInstances writes the output from ListInstances. An account column is
+// added when the instances were listed from multiple accounts.
 func PrintInstances(wrt io.Writer, instances []*manager.Instance) error {
+	var accounts bool
+	for _, instance := range instances {
+		if instance.AccountID != "" {
+			accounts = true
+		}
+	}
+
 	w := tabwriter.NewWriter(wrt, 0, 8, 1, ' ', 0)
-	header := []string{
+	var header []string
+	if accounts {
+		header = append(header, "Account")
+	}
+	header = append(header, []string{
 		"Instance ID",
 		"Name",
 		"State",
@@ -125,13 +189,17 @@ func PrintInstances(wrt io.Writer, instances []*manager.Instance) error {
 		"IP",
 		"Status",
 		"Last pinged",
-	}
+	}...)
 
 	if _, err := fmt.Fprintln(w, strings.Join(header, "\t|\t")); err != nil {
 		return err
 	}
 	for _, instance := range instances {
-		if _, err := fmt.Fprintln(w, instance.TabString()); err != nil {
+		line := instance.TabString()
+		if accounts {
+			line = instance.AccountID + "\t|\t" + line
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
 			return err
 		}
 	}
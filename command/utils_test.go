@@ -52,6 +52,44 @@ i-00000000000000002 | instance 2 | running | ami-db000002 | Amazon Linux 2 | 2.0
 		assert.NotNil(t, actual)
 		assert.Equal(t, expected, actual)
 	})
+
+	t.Run("Print works with accounts", func(t *testing.T) {
+		expected := strings.TrimSpace(`
+Account      | Instance ID         | Name       | State   | Image ID     | Platform       | Version | IP         | Status | Last pinged
+111111111111 | i-00000000000000001 | instance 1 | running | ami-db000001 | Amazon Linux   | 1.0     | 10.0.0.1   | Online | 2018-01-27 13:32
+222222222222 | i-00000000000000002 | instance 2 | running | ami-db000002 | Amazon Linux 2 | 2.0     | 10.0.0.100 | Online | 2018-01-30 13:32
+`)
+		first, second := *input[0], *input[1]
+		first.AccountID = "111111111111"
+		second.AccountID = "222222222222"
+
+		b := new(bytes.Buffer)
+		err := command.PrintInstances(b, []*manager.Instance{&first, &second})
+		actual := strings.TrimSpace(b.String())
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	})
+}
+
+func TestPrintCollapsedOutput(t *testing.T) {
+	input := []*manager.CommandOutput{
+		{InstanceID: "i-00000000000000001", Status: "Success", Output: "Standard output"},
+		{InstanceID: "i-00000000000000002", Status: "Failed", Output: "Standard error"},
+		{InstanceID: "i-00000000000000003", Status: "Success", Output: "Standard output", AccountID: "111111111111"},
+	}
+
+	expected := strings.TrimSpace(`
+i-00000000000000001, 111111111111/i-00000000000000003 - Success:
+Standard output
+
+i-00000000000000002 - Failed:
+Standard error
+`)
+
+	b := new(bytes.Buffer)
+	err := command.PrintCollapsedOutput(b, input)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, strings.TrimSpace(b.String()))
 }
 
 func TestPrintCommandOutput(t *testing.T) {
@@ -108,4 +146,19 @@ error
 		assert.NotNil(t, actual)
 		assert.Equal(t, expected, actual)
 	})
+
+	t.Run("Print works with accounts", func(t *testing.T) {
+		expected := strings.TrimSpace(`
+111111111111/i-00000000000000001 - Success:
+Standard output
+`)
+		output := *input[0]
+		output.AccountID = "111111111111"
+
+		b := new(bytes.Buffer)
+		err := command.PrintCommandOutput(b, &output)
+		actual := strings.TrimSpace(b.String())
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	})
 }
@@ -0,0 +1,386 @@
+package manager
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// Expression is a filter over Instance fields, e.g.:
+//
+//	tag:env=prod and platform~"Amazon Linux" and not name=bastion-* and ping=Online
+//
+// Conditions are written as <field><operator><value>, where the operators are
+// = and != (exact match, * and ? are wildcards) or ~ and !~ (regular expression).
+// Conditions can be combined with and, or, not and parentheses.
+type Expression struct {
+	root node
+}
+
+// ParseExpression parses an expression.
+func ParseExpression(input string) (*Expression, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.value, t.pos)
+	}
+	return &Expression{root: root}, nil
+}
+
+// Match returns true if the instance satisfies the expression.
+func (e *Expression) Match(instance *Instance) bool {
+	return e.root.match(instance)
+}
+
+// TagFilters returns the tag conditions which must hold for every match,
+// so that they can be evaluated server-side by EC2.
+func (e *Expression) TagFilters() []*TagFilter {
+	var out []*TagFilter
+	for _, c := range conjuncts(e.root) {
+		if key := strings.TrimPrefix(c.field, "tag:"); key != c.field && c.op == "=" {
+			out = append(out, &TagFilter{Key: key, Values: []string{c.value}})
+		}
+	}
+	return out
+}
+
+// instanceFilters returns the conditions which must hold for every match, and
+// which can be evaluated server-side by SSM.
+func (e *Expression) instanceFilters() []*ssm.InstanceInformationStringFilter {
+	var out []*ssm.InstanceInformationStringFilter
+	for _, c := range conjuncts(e.root) {
+		if c.op != "=" || strings.ContainsAny(c.value, "*?") {
+			continue
+		}
+		var key string
+		switch c.field {
+		case "id":
+			key = "InstanceIds"
+		case "ping":
+			key = "PingStatus"
+		default:
+			continue
+		}
+		out = append(out, &ssm.InstanceInformationStringFilter{
+			Key:    aws.String(key),
+			Values: aws.StringSlice([]string{c.value}),
+		})
+	}
+	return out
+}
+
+// Return the conditions which are joined by "and" at the top level.
+func conjuncts(n node) []*condition {
+	switch v := n.(type) {
+	case *condition:
+		return []*condition{v}
+	case *andNode:
+		return append(conjuncts(v.left), conjuncts(v.right)...)
+	}
+	return nil
+}
+
+type node interface {
+	match(*Instance) bool
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n *andNode) match(i *Instance) bool {
+	return n.left.match(i) && n.right.match(i)
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n *orNode) match(i *Instance) bool {
+	return n.left.match(i) || n.right.match(i)
+}
+
+type notNode struct {
+	node node
+}
+
+func (n *notNode) match(i *Instance) bool {
+	return !n.node.match(i)
+}
+
+type condition struct {
+	field string
+	op    string
+	value string
+	regex *regexp.Regexp
+}
+
+func (c *condition) match(i *Instance) bool {
+	value, ok := c.lookup(i)
+	matched := ok && c.regex.MatchString(value)
+	if strings.HasPrefix(c.op, "!") {
+		return !matched
+	}
+	return matched
+}
+
+// Look up the field value for an instance. Returns false for missing tags.
+func (c *condition) lookup(i *Instance) (string, bool) {
+	if key := strings.TrimPrefix(c.field, "tag:"); key != c.field {
+		value, ok := i.Tags[key]
+		return value, ok
+	}
+	switch c.field {
+	case "id":
+		return i.InstanceID, true
+	case "name":
+		return i.Name, true
+	case "state":
+		return i.State, true
+	case "image":
+		return i.ImageID, true
+	case "platform":
+		return i.PlatformName, true
+	case "version":
+		return i.PlatformVersion, true
+	case "ip":
+		return i.IPAddress, true
+	case "ping":
+		return i.PingStatus, true
+	case "account":
+		return i.AccountID, true
+	}
+	return "", false
+}
+
+var fields = map[string]string{
+	"id":          "id",
+	"instance-id": "id",
+	"name":        "name",
+	"state":       "state",
+	"image":       "image",
+	"image-id":    "image",
+	"platform":    "platform",
+	"version":     "version",
+	"ip":          "ip",
+	"ping":        "ping",
+	"status":      "ping",
+	"account":     "account",
+}
+
+func newCondition(field, op, value string) (*condition, error) {
+	if !strings.HasPrefix(field, "tag:") {
+		name, ok := fields[strings.ToLower(field)]
+		if !ok {
+			return nil, fmt.Errorf("unknown field: %s", field)
+		}
+		field = name
+	} else if field == "tag:" {
+		return nil, fmt.Errorf("missing tag key")
+	}
+
+	var pattern string
+	switch op {
+	case "=", "!=":
+		pattern = GlobPattern(value)
+	case "~", "!~":
+		pattern = value
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern for %s: %s", field, err)
+	}
+	return &condition{field: field, op: op, value: value, regex: regex}, nil
+}
+
+// GlobPattern converts a glob (where * and ? are wildcards) to an anchored regular expression.
+func GlobPattern(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenWord && strings.EqualFold(t.value, word) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.keyword("not") {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node: n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLeftParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRightParen {
+			return nil, fmt.Errorf("expected ) at position %d", t.pos)
+		}
+		return n, nil
+	case tokenWord:
+		op := p.next()
+		if op.kind != tokenOperator {
+			return nil, fmt.Errorf("expected an operator after %q at position %d", t.value, op.pos)
+		}
+		value := p.next()
+		if value.kind != tokenWord && value.kind != tokenString {
+			return nil, fmt.Errorf("expected a value after %q at position %d", op.value, value.pos)
+		}
+		return newCondition(t.value, op.value, value.value)
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.value, t.pos)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLeftParen, value: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRightParen, value: ")", pos: i})
+			i++
+		case r == '=' || r == '~':
+			tokens = append(tokens, token{kind: tokenOperator, value: string(r), pos: i})
+			i++
+		case r == '!':
+			if i+1 >= len(runes) || (runes[i+1] != '=' && runes[i+1] != '~') {
+				return nil, fmt.Errorf("expected = or ~ after ! at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, value: string(runes[i : i+2]), pos: i})
+			i += 2
+		case r == '"':
+			var b strings.Builder
+			start := i
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string at position %d", start)
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				} else if runes[i] == '"' {
+					break
+				}
+				b.WriteRune(runes[i])
+			}
+			tokens = append(tokens, token{kind: tokenString, value: b.String(), pos: start})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()=~!"`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, value: string(runes[start:i]), pos: start})
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
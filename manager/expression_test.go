@@ -0,0 +1,70 @@
+package manager_test
+
+import (
+	"testing"
+
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpression(t *testing.T) {
+	instance := &manager.Instance{
+		InstanceID:   "i-00000000000000001",
+		Name:         "web-1",
+		PlatformName: "Amazon Linux",
+		PingStatus:   "Online",
+		Tags:         map[string]string{"env": "prod", "Name": "web-1"},
+	}
+
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`tag:env=prod`, true},
+		{`tag:env=dev`, false},
+		{`tag:missing!=dev`, true},
+		{`name=web-*`, true},
+		{`name=web-?`, true},
+		{`name=bastion-*`, false},
+		{`platform~"Amazon Linux"`, true},
+		{`platform!~"^Windows"`, true},
+		{`tag:env=prod and not name=bastion-* and ping=Online`, true},
+		{`tag:env=dev or ping=Online`, true},
+		{`tag:env=dev or (ping=Online and name=db-*)`, false},
+		{`NOT (id=i-00000000000000001)`, false},
+		{`name="web-1"`, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			e, err := manager.ParseExpression(tc.input)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, e.Match(instance))
+		})
+	}
+
+	t.Run("Invalid expressions are rejected", func(t *testing.T) {
+		for _, input := range []string{
+			``,
+			`name`,
+			`name=`,
+			`unknown=value`,
+			`tag:=value`,
+			`name=web and`,
+			`(name=web`,
+			`name="web`,
+			`name!web`,
+			`name~"("`,
+		} {
+			_, err := manager.ParseExpression(input)
+			assert.NotNil(t, err, input)
+		}
+	})
+
+	t.Run("TagFilters only includes required conditions", func(t *testing.T) {
+		e, err := manager.ParseExpression(`tag:env=prod and (tag:role=web or tag:role=db) and not tag:team=ops and tag:app~api`)
+		assert.Nil(t, err)
+		expected := []*manager.TagFilter{{Key: "env", Values: []string{"prod"}}}
+		assert.Equal(t, expected, e.TagFilters())
+	})
+}
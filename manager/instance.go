@@ -11,7 +11,12 @@ import (
 // NewInstance creates a new Instance from ssm.InstanceInformation.
 func NewInstance(ssmInstance *ssm.InstanceInformation, ec2Instance *ec2.Instance) *Instance {
 	var name string
+	var tags map[string]string
 	for _, tag := range ec2Instance.Tags {
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
 		if aws.StringValue(tag.Key) == "Name" {
 			name = aws.StringValue(tag.Value)
 		}
@@ -26,6 +31,7 @@ func NewInstance(ssmInstance *ssm.InstanceInformation, ec2Instance *ec2.Instance
 		IPAddress:        aws.StringValue(ssmInstance.IPAddress),
 		PingStatus:       aws.StringValue(ssmInstance.PingStatus),
 		LastPingDateTime: aws.TimeValue(ssmInstance.LastPingDateTime),
+		Tags:             tags,
 	}
 }
 
@@ -33,15 +39,17 @@ func NewInstance(ssmInstance *ssm.InstanceInformation, ec2Instance *ec2.Instance
 // as collected from SSM and EC2 endpoints. And does not user pointers
 // for all values.
 type Instance struct {
-	InstanceID       string    `json:"instanceId"`
-	Name             string    `json:"name"`
-	State            string    `json:"state"`
-	ImageID          string    `json:"imageId"`
-	PlatformName     string    `json:"platformName"`
-	PlatformVersion  string    `json:"platformVersion"`
-	IPAddress        string    `json:"ipAddress"`
-	PingStatus       string    `json:"pingStatus"`
-	LastPingDateTime time.Time `json:"lastPingDateTime"`
+	AccountID        string            `json:"accountId,omitempty"`
+	InstanceID       string            `json:"instanceId"`
+	Name             string            `json:"name"`
+	State            string            `json:"state"`
+	ImageID          string            `json:"imageId"`
+	PlatformName     string            `json:"platformName"`
+	PlatformVersion  string            `json:"platformVersion"`
+	IPAddress        string            `json:"ipAddress"`
+	PingStatus       string            `json:"pingStatus"`
+	LastPingDateTime time.Time         `json:"lastPingDateTime"`
+	Tags             map[string]string `json:"tags,omitempty"`
 }
 
 // ID returns the InstanceID of an Instance.
@@ -68,3 +76,27 @@ func (i *Instance) TabString() string {
 	}
 	return strings.Join(fields, tab+del+tab)
 }
+
+// NewInstanceStatus creates a new InstanceStatus from ssm.InstanceInformation.
+func NewInstanceStatus(ssmInstance *ssm.InstanceInformation) *InstanceStatus {
+	return &InstanceStatus{
+		InstanceID:   aws.StringValue(ssmInstance.InstanceId),
+		PingStatus:   aws.StringValue(ssmInstance.PingStatus),
+		PlatformType: aws.StringValue(ssmInstance.PlatformType),
+		PlatformName: aws.StringValue(ssmInstance.PlatformName),
+	}
+}
+
+// InstanceStatus describes the SSM agent status of an instance, without the
+// additional information collected from EC2.
+type InstanceStatus struct {
+	InstanceID   string `json:"instanceId"`
+	PingStatus   string `json:"pingStatus"`
+	PlatformType string `json:"platformType"`
+	PlatformName string `json:"platformName"`
+}
+
+// Online returns true if the SSM agent is connected.
+func (i *InstanceStatus) Online() bool {
+	return i.PingStatus == ssm.PingStatusOnline
+}
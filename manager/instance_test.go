@@ -41,6 +41,7 @@ func TestInstance(t *testing.T) {
 		IPAddress:        "10.0.0.1",
 		PingStatus:       "Online",
 		LastPingDateTime: time.Date(2018, time.January, 27, 13, 32, 0, 0, time.UTC),
+		Tags:             map[string]string{"Name": "instance 1"},
 	}
 
 	t.Run("NewInstance works", func(t *testing.T) {
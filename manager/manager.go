@@ -11,9 +11,15 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/resourcegroups"
+	"github.com/aws/aws-sdk-go/service/resourcegroups/resourcegroupsiface"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/ssm"
@@ -37,6 +43,7 @@ func (t *TagFilter) Filter() *ec2.Filter {
 
 // CommandOutput is the return type transmitted over a channel when fetching output.
 type CommandOutput struct {
+	AccountID  string
 	InstanceID string
 	Status     string
 	Output     string
@@ -46,16 +53,21 @@ type CommandOutput struct {
 
 // Manager handles the clients interfacing with AWS.
 type Manager struct {
-	ssmClient    ssmiface.SSMAPI
-	s3Client     s3iface.S3API
-	ec2Client    ec2iface.EC2API
-	extendOutput bool
-	region       string
-	s3Bucket     string
-	s3KeyPrefix  string
+	ssmClient            ssmiface.SSMAPI
+	s3Client             s3iface.S3API
+	ec2Client            ec2iface.EC2API
+	autoScalingClient    autoscalingiface.AutoScalingAPI
+	resourceGroupsClient resourcegroupsiface.ResourceGroupsAPI
+	accountID            string
+	extendOutput         bool
+	region               string
+	s3Bucket             string
+	s3KeyPrefix          string
 }
 
+// Opts holds optional settings for a Manager.
 type Opts struct {
+	AccountID    string
 	ExtendOutput bool
 	S3Bucket     string
 	S3KeyPrefix  string
@@ -68,11 +80,14 @@ func NewManager(sess *session.Session, region string, opts Opts) *Manager {
 		awsCfg.Region = aws.String(region)
 	}
 	m := &Manager{
-		ssmClient: ssm.New(sess, awsCfg),
-		s3Client:  s3.New(sess, awsCfg),
-		ec2Client: ec2.New(sess, awsCfg),
-		region:    region,
+		ssmClient:            ssm.New(sess, awsCfg),
+		s3Client:             s3.New(sess, awsCfg),
+		ec2Client:            ec2.New(sess, awsCfg),
+		autoScalingClient:    autoscaling.New(sess, awsCfg),
+		resourceGroupsClient: resourcegroups.New(sess, awsCfg),
+		region:               region,
 	}
+	m.accountID = opts.AccountID
 	m.extendOutput = opts.ExtendOutput
 	m.s3Bucket = opts.S3Bucket
 	m.s3KeyPrefix = opts.S3KeyPrefix
@@ -80,22 +95,65 @@ func NewManager(sess *session.Session, region string, opts Opts) *Manager {
 }
 
 // NewTestManager creates a new manager for testing purposes.
-func NewTestManager(ssm ssmiface.SSMAPI, s3 s3iface.S3API, ec2 ec2iface.EC2API) *Manager {
-	return &Manager{
+func NewTestManager(ssm ssmiface.SSMAPI, s3 s3iface.S3API, ec2 ec2iface.EC2API, opts ...TestOption) *Manager {
+	m := &Manager{
 		ssmClient: ssm,
 		s3Client:  s3,
 		ec2Client: ec2,
 		region:    "eu-west-1",
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// TestOption configures a manager created with NewTestManager.
+type TestOption func(*Manager)
+
+// WithAutoScaling sets the Auto Scaling client of a test manager.
+func WithAutoScaling(client autoscalingiface.AutoScalingAPI) TestOption {
+	return func(m *Manager) {
+		m.autoScalingClient = client
+	}
+}
+
+// WithResourceGroups sets the Resource Groups client of a test manager.
+func WithResourceGroups(client resourcegroupsiface.ResourceGroupsAPI) TestOption {
+	return func(m *Manager) {
+		m.resourceGroupsClient = client
+	}
+}
+
+// WithAccountID sets the account ID of a test manager.
+func WithAccountID(id string) TestOption {
+	return func(m *Manager) {
+		m.accountID = id
+	}
+}
+
+// AccountID returns the ID of the account the Manager was created for (if set).
+func (m *Manager) AccountID() string {
+	return m.accountID
 }
 
 // ListInstances fetches a list of instances managed by SSM. Paginates until all responses have been collected.
 func (m *Manager) ListInstances(limit int64, tagFilters []*TagFilter) ([]*Instance, error) {
+	return m.ListInstancesWhere(limit, tagFilters, nil)
+}
+
+// ListInstancesWhere fetches a list of instances managed by SSM which match the expression (if not nil).
+// Conditions on tags, ping status and instance ids are evaluated server-side when they hold for all matches.
+func (m *Manager) ListInstancesWhere(limit int64, tagFilters []*TagFilter, where *Expression) ([]*Instance, error) {
 	var out []*Instance
 
 	input := &ssm.DescribeInstanceInformationInput{
 		MaxResults: &limit,
 	}
+	if where != nil {
+		input.Filters = where.instanceFilters()
+		tagFilters = append(tagFilters, where.TagFilters()...)
+	}
 
 	for {
 		response, err := m.ssmClient.DescribeInstanceInformation(input)
@@ -109,7 +167,118 @@ func (m *Manager) ListInstances(limit int64, tagFilters []*TagFilter) ([]*Instan
 
 		// NOTE: ec2Info will be a shorter list when filtering is applied.
 		for k := range ec2Instances {
-			out = append(out, NewInstance(ssmInstances[k], ec2Instances[k]))
+			instance := NewInstance(ssmInstances[k], ec2Instances[k])
+			instance.AccountID = m.accountID
+			if where != nil && !where.Match(instance) {
+				continue
+			}
+			out = append(out, instance)
+		}
+		if response.NextToken == nil {
+			break
+		}
+		input.NextToken = response.NextToken
+	}
+
+	return out, nil
+}
+
+// DescribeInstanceStatus fetches the SSM agent status for the given instance ids. Instance ids which
+// are not managed by SSM are omitted from the result.
+func (m *Manager) DescribeInstanceStatus(instanceIds []string) ([]*InstanceStatus, error) {
+	var out []*InstanceStatus
+
+	// The InstanceIds filter accepts at most 50 values.
+	for start := 0; start < len(instanceIds); start += 50 {
+		end := start + 50
+		if end > len(instanceIds) {
+			end = len(instanceIds)
+		}
+		input := &ssm.DescribeInstanceInformationInput{
+			Filters: []*ssm.InstanceInformationStringFilter{
+				{
+					Key:    aws.String("InstanceIds"),
+					Values: aws.StringSlice(instanceIds[start:end]),
+				},
+			},
+		}
+
+		for {
+			response, err := m.ssmClient.DescribeInstanceInformation(input)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to describe instance information")
+			}
+			for _, instance := range response.InstanceInformationList {
+				out = append(out, NewInstanceStatus(instance))
+			}
+			if response.NextToken == nil {
+				break
+			}
+			input.NextToken = response.NextToken
+		}
+	}
+
+	return out, nil
+}
+
+// AutoScalingGroupInstances fetches the instance ids for the instances in an Auto Scaling group.
+func (m *Manager) AutoScalingGroupInstances(name string) ([]string, error) {
+	var out []string
+	var found bool
+
+	input := &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: aws.StringSlice([]string{name}),
+	}
+
+	for {
+		response, err := m.autoScalingClient.DescribeAutoScalingGroups(input)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to describe auto scaling groups")
+		}
+		for _, group := range response.AutoScalingGroups {
+			found = true
+			for _, instance := range group.Instances {
+				out = append(out, aws.StringValue(instance.InstanceId))
+			}
+		}
+		if response.NextToken == nil {
+			break
+		}
+		input.NextToken = response.NextToken
+	}
+
+	if !found {
+		return nil, errors.Errorf("auto scaling group not found: %s", name)
+	}
+	return out, nil
+}
+
+// ResourceGroupInstances fetches the instance ids for the EC2 instances in a resource group.
+func (m *Manager) ResourceGroupInstances(name string) ([]string, error) {
+	var out []string
+
+	input := &resourcegroups.ListGroupResourcesInput{
+		GroupName: aws.String(name),
+		Filters: []*resourcegroups.ResourceFilter{
+			{
+				Name:   aws.String(resourcegroups.ResourceFilterNameResourceType),
+				Values: aws.StringSlice([]string{"AWS::EC2::Instance"}),
+			},
+		},
+	}
+
+	for {
+		response, err := m.resourceGroupsClient.ListGroupResources(input)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list group resources")
+		}
+		for _, resource := range response.ResourceIdentifiers {
+			// The resource for an instance is on the form: instance/<instance-id>
+			resourceArn, err := arn.Parse(aws.StringValue(resource.ResourceArn))
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse resource arn")
+			}
+			out = append(out, strings.TrimPrefix(resourceArn.Resource, "instance/"))
 		}
 		if response.NextToken == nil {
 			break
@@ -164,6 +333,98 @@ func (m *Manager) DescribeDocument(name string) (*DocumentDescription, error) {
 	return document, nil
 }
 
+// PushedDocument is the result of pushing a document.
+type PushedDocument struct {
+	Version   string
+	Created   bool
+	Unchanged bool
+}
+
+// PushDocument creates a command document, or adds a new version of it if the
+// document already exists. If the content is the same as the latest version,
+// no version is added and the latest version is returned instead. The version
+// is made the default when setDefault is true (a new document always uses its
+// first version).
+func (m *Manager) PushDocument(name, content, format string, setDefault bool) (*PushedDocument, error) {
+	created, err := m.ssmClient.CreateDocument(&ssm.CreateDocumentInput{
+		Name:           aws.String(name),
+		Content:        aws.String(content),
+		DocumentFormat: aws.String(format),
+		DocumentType:   aws.String(ssm.DocumentTypeCommand),
+	})
+	if err == nil {
+		return &PushedDocument{Version: aws.StringValue(created.DocumentDescription.DocumentVersion), Created: true}, nil
+	}
+	if e, ok := err.(awserr.Error); !ok || e.Code() != ssm.ErrCodeDocumentAlreadyExists {
+		return nil, errors.Wrap(err, "failed to create document")
+	}
+
+	pushed := &PushedDocument{}
+	updated, err := m.ssmClient.UpdateDocument(&ssm.UpdateDocumentInput{
+		Name:            aws.String(name),
+		Content:         aws.String(content),
+		DocumentFormat:  aws.String(format),
+		DocumentVersion: aws.String("$LATEST"),
+	})
+	if e, ok := err.(awserr.Error); ok && e.Code() == ssm.ErrCodeDuplicateDocumentContent {
+		described, err := m.ssmClient.DescribeDocument(&ssm.DescribeDocumentInput{
+			Name:            aws.String(name),
+			DocumentVersion: aws.String("$LATEST"),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to describe document")
+		}
+		pushed.Version = aws.StringValue(described.Document.LatestVersion)
+		pushed.Unchanged = true
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to update document")
+	} else {
+		pushed.Version = aws.StringValue(updated.DocumentDescription.DocumentVersion)
+	}
+
+	if setDefault {
+		_, err := m.ssmClient.UpdateDocumentDefaultVersion(&ssm.UpdateDocumentDefaultVersionInput{
+			Name:            aws.String(name),
+			DocumentVersion: aws.String(pushed.Version),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to set version %s as the default", pushed.Version)
+		}
+	}
+	return pushed, nil
+}
+
+// GetDocument returns the content of a document in the given format (JSON or
+// YAML). The default version is used if no version is given, and the document
+// format is used if no format is given.
+func (m *Manager) GetDocument(name, version, format string) (string, error) {
+	input := &ssm.GetDocumentInput{
+		Name: aws.String(name),
+	}
+	if version != "" {
+		input.DocumentVersion = aws.String(version)
+	}
+	if format != "" {
+		input.DocumentFormat = aws.String(format)
+	}
+	response, err := m.ssmClient.GetDocument(input)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get document")
+	}
+	return aws.StringValue(response.Content), nil
+}
+
+// DeleteDocument deletes a document and all its versions.
+func (m *Manager) DeleteDocument(name string) error {
+	_, err := m.ssmClient.DeleteDocument(&ssm.DeleteDocumentInput{
+		Name: aws.String(name),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to delete document")
+	}
+	return nil
+}
+
 // describeInstances retrieves additional information about SSM managed instances from EC2.
 func (m *Manager) describeInstances(instances []*ssm.InstanceInformation, tagFilters []*TagFilter) (map[string]*ssm.InstanceInformation, map[string]*ec2.Instance, error) {
 	var ids []*string
@@ -295,6 +556,7 @@ func (m *Manager) pollInstanceOutput(ctx context.Context, instanceID string, com
 
 func (m *Manager) newCommandOutput(result *ssm.GetCommandInvocationOutput, err error) (*CommandOutput, bool) {
 	out := &CommandOutput{
+		AccountID:  m.accountID,
 		InstanceID: aws.StringValue(result.InstanceId),
 		Status:     aws.StringValue(result.StatusDetails),
 		Output:     "",
@@ -2,6 +2,7 @@ package manager_test
 
 import (
 	"context"
+	"errors"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ssm"
@@ -113,6 +114,7 @@ var (
 			IPAddress:        "10.0.0.1",
 			PingStatus:       "Online",
 			LastPingDateTime: time.Date(2018, time.January, 27, 13, 32, 0, 0, time.UTC),
+			Tags:             map[string]string{"Name": "instance 1"},
 		},
 		{
 			InstanceID:       "i-00000000000000002",
@@ -124,6 +126,7 @@ var (
 			IPAddress:        "10.0.0.100",
 			PingStatus:       "Online",
 			LastPingDateTime: time.Date(2018, time.January, 30, 13, 32, 0, 0, time.UTC),
+			Tags:             map[string]string{"Name": "instance 2"},
 		},
 	}
 
@@ -239,6 +242,26 @@ func TestList(t *testing.T) {
 		assert.ElementsMatch(t, expected, actual)
 	})
 
+	t.Run("Expression works", func(t *testing.T) {
+		where, err := manager.ParseExpression(`tag:Name="instance 2" and ping=Online and platform~Linux`)
+		assert.Nil(t, err)
+
+		expected := outputInstances[1:]
+		actual, err := m.ListInstancesWhere(50, nil, where)
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, expected, actual)
+	})
+
+	t.Run("Expression is pushed down to SSM", func(t *testing.T) {
+		where, err := manager.ParseExpression(`id=i-00000000000000001 and ping=Online`)
+		assert.Nil(t, err)
+
+		expected := outputInstances[:1]
+		actual, err := m.ListInstancesWhere(1, nil, where)
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, expected, actual)
+	})
+
 	t.Run("Errors are propagated", func(t *testing.T) {
 		ssmMock.Error = true
 		defer func() {
@@ -252,6 +275,94 @@ func TestList(t *testing.T) {
 	})
 }
 
+func TestDescribeInstanceStatus(t *testing.T) {
+	ssmMock := &manager.MockSSM{
+		Error:     false,
+		NextToken: "",
+		Instances: ssmInstances,
+	}
+
+	m := manager.NewTestManager(ssmMock, nil, nil)
+
+	t.Run("Describe instance status works", func(t *testing.T) {
+		expected := []*manager.InstanceStatus{
+			{
+				InstanceID:   "i-00000000000000002",
+				PingStatus:   "Online",
+				PlatformName: "Amazon Linux 2",
+			},
+		}
+		actual, err := m.DescribeInstanceStatus([]string{"i-00000000000000002", "i-00000000000000003"})
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("Errors are propagated", func(t *testing.T) {
+		ssmMock.Error = true
+		defer func() {
+			ssmMock.Error = false
+		}()
+
+		actual, err := m.DescribeInstanceStatus([]string{"i-00000000000000001"})
+		assert.EqualError(t, err, "failed to describe instance information: expected")
+		assert.Nil(t, actual)
+	})
+}
+
+func TestGroupInstances(t *testing.T) {
+	autoScalingMock := &manager.MockAutoScaling{
+		Error: false,
+		Groups: map[string][]string{
+			"web": {"i-00000000000000001", "i-00000000000000002"},
+		},
+	}
+	resourceGroupsMock := &manager.MockResourceGroups{
+		Error: false,
+		Groups: map[string][]string{
+			"web": {
+				"arn:aws:ec2:eu-west-1:111111111111:instance/i-00000000000000001",
+				"arn:aws:ec2:eu-west-1:111111111111:instance/i-00000000000000002",
+			},
+		},
+	}
+
+	m := manager.NewTestManager(nil, nil, nil, manager.WithAutoScaling(autoScalingMock), manager.WithResourceGroups(resourceGroupsMock))
+
+	t.Run("Auto Scaling group works", func(t *testing.T) {
+		expected := []string{"i-00000000000000001", "i-00000000000000002"}
+		actual, err := m.AutoScalingGroupInstances("web")
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("Missing Auto Scaling group fails", func(t *testing.T) {
+		actual, err := m.AutoScalingGroupInstances("db")
+		assert.EqualError(t, err, "auto scaling group not found: db")
+		assert.Nil(t, actual)
+	})
+
+	t.Run("Resource group works", func(t *testing.T) {
+		expected := []string{"i-00000000000000001", "i-00000000000000002"}
+		actual, err := m.ResourceGroupInstances("web")
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("Errors are propagated", func(t *testing.T) {
+		autoScalingMock.Error = true
+		resourceGroupsMock.Error = true
+		defer func() {
+			autoScalingMock.Error = false
+			resourceGroupsMock.Error = false
+		}()
+
+		_, err := m.AutoScalingGroupInstances("web")
+		assert.EqualError(t, err, "failed to describe auto scaling groups: expected")
+		_, err = m.ResourceGroupInstances("web")
+		assert.EqualError(t, err, "failed to list group resources: expected")
+	})
+}
+
 func TestListDocumentsCommand(t *testing.T) {
 	ssmMock := &manager.MockSSM{
 		Error:         false,
@@ -351,6 +462,98 @@ func TestDescribeDocumentsCommand(t *testing.T) {
 	})
 }
 
+// Fails to set the default version of documents.
+type failingDefaultSSM struct {
+	*manager.MockSSM
+}
+
+func (mock *failingDefaultSSM) UpdateDocumentDefaultVersion(input *ssm.UpdateDocumentDefaultVersionInput) (*ssm.UpdateDocumentDefaultVersionOutput, error) {
+	return nil, errors.New("expected")
+}
+
+func TestPushDocument(t *testing.T) {
+	ssmMock := &manager.MockSSM{
+		DocumentVersions: map[string][]string{},
+		DefaultVersions:  map[string]string{},
+	}
+	m := manager.NewTestManager(ssmMock, nil, nil)
+
+	t.Run("Push creates the document", func(t *testing.T) {
+		pushed, err := m.PushDocument("restart-app", "content: 1", "YAML", false)
+		assert.Nil(t, err)
+		assert.Equal(t, &manager.PushedDocument{Version: "1", Created: true}, pushed)
+		assert.Equal(t, "1", ssmMock.DefaultVersions["restart-app"])
+	})
+
+	t.Run("Push adds a new version", func(t *testing.T) {
+		pushed, err := m.PushDocument("restart-app", "content: 2", "YAML", false)
+		assert.Nil(t, err)
+		assert.Equal(t, &manager.PushedDocument{Version: "2"}, pushed)
+		assert.Equal(t, "1", ssmMock.DefaultVersions["restart-app"])
+	})
+
+	t.Run("Push sets the default version", func(t *testing.T) {
+		pushed, err := m.PushDocument("restart-app", "content: 3", "YAML", true)
+		assert.Nil(t, err)
+		assert.Equal(t, &manager.PushedDocument{Version: "3"}, pushed)
+		assert.Equal(t, "3", ssmMock.DefaultVersions["restart-app"])
+	})
+
+	t.Run("Unchanged content returns the latest version", func(t *testing.T) {
+		ssmMock.DefaultVersions["restart-app"] = "1"
+		pushed, err := m.PushDocument("restart-app", "content: 3", "YAML", false)
+		assert.Nil(t, err)
+		assert.Equal(t, &manager.PushedDocument{Version: "3", Unchanged: true}, pushed)
+		assert.Equal(t, "1", ssmMock.DefaultVersions["restart-app"])
+		assert.Len(t, ssmMock.DocumentVersions["restart-app"], 3)
+	})
+
+	t.Run("Unchanged content sets the default version", func(t *testing.T) {
+		pushed, err := m.PushDocument("restart-app", "content: 3", "YAML", true)
+		assert.Nil(t, err)
+		assert.Equal(t, &manager.PushedDocument{Version: "3", Unchanged: true}, pushed)
+		assert.Equal(t, "3", ssmMock.DefaultVersions["restart-app"])
+	})
+
+	t.Run("Failing to set the default version includes the version", func(t *testing.T) {
+		failing := manager.NewTestManager(&failingDefaultSSM{ssmMock}, nil, nil)
+		pushed, err := failing.PushDocument("restart-app", "content: 4", "YAML", true)
+		assert.EqualError(t, err, "failed to set version 4 as the default: expected")
+		assert.Nil(t, pushed)
+		assert.Equal(t, "3", ssmMock.DefaultVersions["restart-app"])
+	})
+
+	t.Run("Get returns the default version", func(t *testing.T) {
+		content, err := m.GetDocument("restart-app", "", "")
+		assert.Nil(t, err)
+		assert.Equal(t, "content: 3", content)
+	})
+
+	t.Run("Get returns a specific version", func(t *testing.T) {
+		content, err := m.GetDocument("restart-app", "2", "")
+		assert.Nil(t, err)
+		assert.Equal(t, "content: 2", content)
+	})
+
+	t.Run("Delete works", func(t *testing.T) {
+		err := m.DeleteDocument("restart-app")
+		assert.Nil(t, err)
+		_, err = m.GetDocument("restart-app", "", "")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Errors are propagated", func(t *testing.T) {
+		ssmMock.Error = true
+		defer func() {
+			ssmMock.Error = false
+		}()
+		_, err := m.PushDocument("restart-app", "content: 1", "YAML", false)
+		assert.EqualError(t, err, "failed to create document: expected")
+		err = m.DeleteDocument("restart-app")
+		assert.EqualError(t, err, "failed to delete document: expected")
+	})
+}
+
 /*
 func TestDescribeDocumentCommand(t *testing.T) {
 }*/
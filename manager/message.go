@@ -0,0 +1,163 @@
+package manager
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message types used on the Session Manager data channel.
+const (
+	InputStreamMessage      = "input_stream_data"
+	OutputStreamMessage     = "output_stream_data"
+	AcknowledgeMessage      = "acknowledge"
+	ChannelClosedMessage    = "channel_closed"
+	StartPublicationMessage = "start_publication"
+	PausePublicationMessage = "pause_publication"
+)
+
+// Payload types used on the Session Manager data channel.
+const (
+	PayloadOutput            uint32 = 1
+	PayloadError             uint32 = 2
+	PayloadSize              uint32 = 3
+	PayloadParameter         uint32 = 4
+	PayloadHandshakeRequest  uint32 = 5
+	PayloadHandshakeResponse uint32 = 6
+	PayloadHandshakeComplete uint32 = 7
+	PayloadFlag              uint32 = 10
+)
+
+// Field offsets in a serialized ClientMessage. The header length covers
+// everything up to (but not including) the payload length.
+const (
+	messageTypeLength    = 32
+	messageHeaderLength  = 116
+	messageTypeOffset    = 4
+	schemaVersionOffset  = messageTypeOffset + messageTypeLength
+	createdDateOffset    = schemaVersionOffset + 4
+	sequenceNumberOffset = createdDateOffset + 8
+	flagsOffset          = sequenceNumberOffset + 8
+	messageIDOffset      = flagsOffset + 8
+	payloadDigestOffset  = messageIDOffset + 16
+	payloadTypeOffset    = payloadDigestOffset + 32
+	payloadLengthOffset  = payloadTypeOffset + 4
+	payloadOffset        = payloadLengthOffset + 4
+	acknowledgeFlags     = 3
+	messageSchemaVersion = 1
+)
+
+// ClientMessage is a single message on the Session Manager data channel.
+type ClientMessage struct {
+	MessageType    string
+	SchemaVersion  uint32
+	CreatedDate    time.Time
+	SequenceNumber int64
+	Flags          uint64
+	MessageID      string
+	PayloadType    uint32
+	Payload        []byte
+}
+
+// NewClientMessage creates a message with a random ID.
+func NewClientMessage(messageType string, sequenceNumber int64, payloadType uint32, payload []byte) *ClientMessage {
+	return &ClientMessage{
+		MessageType:    messageType,
+		SchemaVersion:  messageSchemaVersion,
+		CreatedDate:    time.Now(),
+		SequenceNumber: sequenceNumber,
+		MessageID:      newUUID(),
+		PayloadType:    payloadType,
+		Payload:        payload,
+	}
+}
+
+// MarshalBinary serializes the message in the data channel wire format.
+func (m *ClientMessage) MarshalBinary() ([]byte, error) {
+	id, err := uuidBytes(m.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.MessageType) > messageTypeLength {
+		return nil, errors.Errorf("message type too long: %s", m.MessageType)
+	}
+	b := make([]byte, payloadOffset+len(m.Payload))
+	digest := sha256.Sum256(m.Payload)
+
+	binary.BigEndian.PutUint32(b, messageHeaderLength)
+	copy(b[messageTypeOffset:], m.MessageType+strings.Repeat(" ", messageTypeLength-len(m.MessageType)))
+	binary.BigEndian.PutUint32(b[schemaVersionOffset:], m.SchemaVersion)
+	binary.BigEndian.PutUint64(b[createdDateOffset:], uint64(m.CreatedDate.UnixNano()/int64(time.Millisecond)))
+	binary.BigEndian.PutUint64(b[sequenceNumberOffset:], uint64(m.SequenceNumber))
+	binary.BigEndian.PutUint64(b[flagsOffset:], m.Flags)
+	copy(b[messageIDOffset:], id)
+	copy(b[payloadDigestOffset:], digest[:])
+	binary.BigEndian.PutUint32(b[payloadTypeOffset:], m.PayloadType)
+	binary.BigEndian.PutUint32(b[payloadLengthOffset:], uint32(len(m.Payload)))
+	copy(b[payloadOffset:], m.Payload)
+	return b, nil
+}
+
+// UnmarshalBinary parses a message in the data channel wire format and verifies the payload digest.
+func (m *ClientMessage) UnmarshalBinary(b []byte) error {
+	if len(b) < payloadOffset {
+		return errors.Errorf("message too short: %d bytes", len(b))
+	}
+	headerLength := binary.BigEndian.Uint32(b)
+	if headerLength < payloadLengthOffset || int(headerLength)+4 > len(b) {
+		return errors.Errorf("invalid header length: %d", headerLength)
+	}
+	payloadLength := binary.BigEndian.Uint32(b[headerLength:])
+	start := int(headerLength) + 4
+	if start+int(payloadLength) > len(b) {
+		return errors.Errorf("invalid payload length: %d", payloadLength)
+	}
+
+	m.MessageType = strings.TrimRight(string(bytes.TrimRight(b[messageTypeOffset:schemaVersionOffset], "\x00")), " ")
+	m.SchemaVersion = binary.BigEndian.Uint32(b[schemaVersionOffset:])
+	m.CreatedDate = time.Unix(0, int64(binary.BigEndian.Uint64(b[createdDateOffset:]))*int64(time.Millisecond))
+	m.SequenceNumber = int64(binary.BigEndian.Uint64(b[sequenceNumberOffset:]))
+	m.Flags = binary.BigEndian.Uint64(b[flagsOffset:])
+	m.MessageID = formatUUID(b[messageIDOffset:payloadDigestOffset])
+	m.PayloadType = binary.BigEndian.Uint32(b[payloadTypeOffset:])
+	m.Payload = append([]byte(nil), b[start:start+int(payloadLength)]...)
+
+	digest := sha256.Sum256(m.Payload)
+	if !bytes.Equal(digest[:], b[payloadDigestOffset:payloadTypeOffset]) {
+		return errors.Errorf("payload digest mismatch for message %s", m.MessageID)
+	}
+	return nil
+}
+
+// Generate a random (version 4) UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// Convert a UUID to its wire format, where the least significant half comes first.
+func uuidBytes(id string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.Replace(id, "-", "", -1))
+	if err != nil || len(b) != 16 {
+		return nil, errors.Errorf("invalid message id: %s", id)
+	}
+	return append(b[8:], b[:8]...), nil
+}
+
+// Convert a UUID from its wire format.
+func formatUUID(b []byte) string {
+	u := append(append([]byte(nil), b[8:16]...), b[0:8]...)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
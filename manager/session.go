@@ -0,0 +1,472 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+const (
+	sessionClientVersion = "1.2.0.0"
+	sessionChunkSize     = 1024
+)
+
+// ResendInterval is how long to wait for an acknowledgement before a message is sent again.
+var ResendInterval = time.Second
+
+// HandshakeTimeout is how long to wait for the handshake from the agent. Older agents
+// do not send one, and the session is treated as ready (with an unknown agent version)
+// once the timeout is reached.
+var HandshakeTimeout = 10 * time.Second
+
+// ErrSessionClosed is returned when writing to a session which has been closed.
+var ErrSessionClosed = errors.New("session closed")
+
+// Session is an interactive Session Manager session. Input is sent with Write, and
+// output from the instance is written to the output given when the session was opened.
+// The message sent by the agent when it closes the session is written to stderr.
+type Session struct {
+	ID        string
+	conn      *websocket.Conn
+	output    io.Writer
+	terminate func() error
+
+	// Websocket writes must not be concurrent.
+	writeLock sync.Mutex
+
+	lock     sync.Mutex
+	sequence int64
+	unacked  map[string]*pendingMessage
+	expected int64
+	buffer   map[int64]*ClientMessage
+
+	agentVersion string
+	ready        chan struct{}
+	readyOnce    sync.Once
+	done         chan struct{}
+	doneOnce     sync.Once
+	closeOnce    sync.Once
+	err          error
+}
+
+type pendingMessage struct {
+	message *ClientMessage
+	sentAt  time.Time
+}
+
+type openDataChannelInput struct {
+	MessageSchemaVersion string
+	RequestId            string
+	TokenValue           string
+	ClientId             string
+	ClientVersion        string
+}
+
+type acknowledgeContent struct {
+	AcknowledgedMessageType           string
+	AcknowledgedMessageId             string
+	AcknowledgedMessageSequenceNumber int64
+	IsSequentialMessage               bool
+}
+
+type handshakeRequest struct {
+	AgentVersion           string
+	RequestedClientActions []struct {
+		ActionType       string
+		ActionParameters json.RawMessage
+	}
+}
+
+type handshakeResponse struct {
+	ClientVersion          string
+	ProcessedClientActions []processedClientAction
+	Errors                 []string
+}
+
+type processedClientAction struct {
+	ActionType   string
+	ActionStatus int
+	Error        string `json:",omitempty"`
+}
+
+type channelClosed struct {
+	SessionId string
+	Output    string
+}
+
+// StartSession starts a Session Manager session on an instance. The document defaults to a shell
+// session if empty.
+func (m *Manager) StartSession(instanceID, document string, parameters map[string][]string, output io.Writer) (*Session, error) {
+	input := &ssm.StartSessionInput{
+		Target: aws.String(instanceID),
+	}
+	if document != "" {
+		input.DocumentName = aws.String(document)
+	}
+	if len(parameters) > 0 {
+		input.Parameters = make(map[string][]*string)
+		for k, v := range parameters {
+			input.Parameters[k] = aws.StringSlice(v)
+		}
+	}
+
+	res, err := m.ssmClient.StartSession(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start session")
+	}
+	id := aws.StringValue(res.SessionId)
+
+	s, err := OpenSession(aws.StringValue(res.StreamUrl), aws.StringValue(res.TokenValue), output)
+	if err != nil {
+		m.TerminateSession(id)
+		return nil, err
+	}
+	s.ID = id
+	s.terminate = func() error {
+		return m.TerminateSession(id)
+	}
+	return s, nil
+}
+
+// TerminateSession terminates a Session Manager session.
+func (m *Manager) TerminateSession(id string) error {
+	_, err := m.ssmClient.TerminateSession(&ssm.TerminateSessionInput{
+		SessionId: aws.String(id),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to terminate session")
+	}
+	return nil
+}
+
+// OpenSession connects to the data channel of a session which has been started.
+func OpenSession(streamURL, token string, output io.Writer) (*Session, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(streamURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open data channel")
+	}
+
+	open, err := json.Marshal(&openDataChannelInput{
+		MessageSchemaVersion: "1.0",
+		RequestId:            newUUID(),
+		TokenValue:           token,
+		ClientId:             newUUID(),
+		ClientVersion:        sessionClientVersion,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, open); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to open data channel")
+	}
+
+	s := &Session{
+		conn:    conn,
+		output:  output,
+		unacked: make(map[string]*pendingMessage),
+		buffer:  make(map[int64]*ClientMessage),
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.readLoop()
+	go s.resendLoop()
+	go s.handshakeTimeout(HandshakeTimeout)
+	return s, nil
+}
+
+// Write sends input to the session. It blocks until the handshake with the agent is complete
+// (or HandshakeTimeout is reached).
+func (s *Session) Write(p []byte) (int, error) {
+	select {
+	case <-s.ready:
+	case <-s.done:
+		return 0, ErrSessionClosed
+	}
+	for i := 0; i < len(p); i += sessionChunkSize {
+		end := i + sessionChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := s.send(PayloadOutput, append([]byte(nil), p[i:end]...)); err != nil {
+			return i, err
+		}
+	}
+	return len(p), nil
+}
+
+// Resize sets the terminal size of the session.
+func (s *Session) Resize(cols, rows int) error {
+	select {
+	case <-s.ready:
+	case <-s.done:
+		return ErrSessionClosed
+	}
+	payload, err := json.Marshal(map[string]int{"cols": cols, "rows": rows})
+	if err != nil {
+		return err
+	}
+	return s.send(PayloadSize, payload)
+}
+
+// AgentVersion returns the version of the SSM agent on the instance. It blocks until
+// the handshake with the agent is complete, and the version is empty if the agent did
+// not send a handshake before HandshakeTimeout.
+func (s *Session) AgentVersion() (string, error) {
+	select {
+	case <-s.ready:
+		return s.agentVersion, nil
+	case <-s.done:
+		if s.err != nil {
+			return "", s.err
+		}
+		return "", ErrSessionClosed
+	}
+}
+
+// Done returns a channel which is closed when the session has ended.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait blocks until the session has ended and returns the error which ended it (if any).
+func (s *Session) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Close closes the data channel and terminates the session (if it was started by a Manager).
+// It is safe to call Close more than once.
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.writeLock.Lock()
+		s.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		s.writeLock.Unlock()
+		s.close(nil)
+
+		if s.terminate != nil {
+			err = s.terminate()
+		}
+	})
+	return err
+}
+
+func (s *Session) close(err error) {
+	s.doneOnce.Do(func() {
+		s.err = err
+		close(s.done)
+		s.conn.Close()
+	})
+}
+
+func (s *Session) readLoop() {
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				err = nil
+			}
+			select {
+			case <-s.done:
+				err = nil
+			default:
+			}
+			s.close(err)
+			return
+		}
+
+		msg := &ClientMessage{}
+		if err := msg.UnmarshalBinary(data); err != nil {
+			s.close(errors.Wrap(err, "invalid message"))
+			return
+		}
+
+		switch msg.MessageType {
+		case AcknowledgeMessage:
+			var ack acknowledgeContent
+			if err := json.Unmarshal(msg.Payload, &ack); err != nil {
+				s.close(errors.Wrap(err, "invalid acknowledgement"))
+				return
+			}
+			s.lock.Lock()
+			delete(s.unacked, ack.AcknowledgedMessageId)
+			s.lock.Unlock()
+		case OutputStreamMessage:
+			if err := s.acknowledge(msg); err != nil {
+				s.close(err)
+				return
+			}
+			for _, m := range s.receive(msg) {
+				if err := s.process(m); err != nil {
+					s.close(err)
+					return
+				}
+			}
+		case ChannelClosedMessage:
+			var closed channelClosed
+			json.Unmarshal(msg.Payload, &closed)
+			// The reason is not part of the output, which may be a stream (e.g. for SSH).
+			if closed.Output != "" {
+				fmt.Fprintln(os.Stderr, closed.Output)
+			}
+			s.close(nil)
+			return
+		}
+	}
+}
+
+// Buffer a message and return the messages which are ready in sequence. Duplicates are dropped.
+func (s *Session) receive(msg *ClientMessage) []*ClientMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if msg.SequenceNumber < s.expected {
+		return nil
+	}
+	s.buffer[msg.SequenceNumber] = msg
+
+	var out []*ClientMessage
+	for {
+		m, ok := s.buffer[s.expected]
+		if !ok {
+			break
+		}
+		delete(s.buffer, s.expected)
+		out = append(out, m)
+		s.expected++
+	}
+	return out
+}
+
+func (s *Session) process(msg *ClientMessage) error {
+	switch msg.PayloadType {
+	case PayloadOutput, PayloadError:
+		if _, err := s.output.Write(msg.Payload); err != nil {
+			return err
+		}
+	case PayloadHandshakeRequest:
+		var req handshakeRequest
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			return errors.Wrap(err, "invalid handshake request")
+		}
+		res := &handshakeResponse{ClientVersion: sessionClientVersion, Errors: []string{}}
+		for _, action := range req.RequestedClientActions {
+			processed := processedClientAction{ActionType: action.ActionType, ActionStatus: 1}
+			if action.ActionType != "SessionType" {
+				processed.ActionStatus = 3
+				processed.Error = "unsupported action: " + action.ActionType
+				res.Errors = append(res.Errors, processed.Error)
+			}
+			res.ProcessedClientActions = append(res.ProcessedClientActions, processed)
+		}
+		payload, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		if err := s.send(PayloadHandshakeResponse, payload); err != nil {
+			return err
+		}
+		s.readyOnce.Do(func() {
+			s.agentVersion = req.AgentVersion
+			close(s.ready)
+		})
+	}
+	return nil
+}
+
+// Send an input message and keep it until it has been acknowledged.
+func (s *Session) send(payloadType uint32, payload []byte) error {
+	select {
+	case <-s.done:
+		return ErrSessionClosed
+	default:
+	}
+	s.lock.Lock()
+	msg := NewClientMessage(InputStreamMessage, s.sequence, payloadType, payload)
+	s.sequence++
+	s.unacked[msg.MessageID] = &pendingMessage{message: msg, sentAt: time.Now()}
+	s.lock.Unlock()
+	return s.write(msg)
+}
+
+func (s *Session) acknowledge(msg *ClientMessage) error {
+	payload, err := json.Marshal(&acknowledgeContent{
+		AcknowledgedMessageType:           msg.MessageType,
+		AcknowledgedMessageId:             msg.MessageID,
+		AcknowledgedMessageSequenceNumber: msg.SequenceNumber,
+		IsSequentialMessage:               true,
+	})
+	if err != nil {
+		return err
+	}
+	ack := NewClientMessage(AcknowledgeMessage, 0, 0, payload)
+	ack.Flags = acknowledgeFlags
+	return s.write(ack)
+}
+
+func (s *Session) write(msg *ClientMessage) error {
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if err := s.conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return errors.Wrap(err, "failed to write to data channel")
+	}
+	return nil
+}
+
+// Mark the session as ready if the agent has not sent a handshake in time.
+func (s *Session) handshakeTimeout(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		s.readyOnce.Do(func() {
+			close(s.ready)
+		})
+	case <-s.ready:
+	case <-s.done:
+	}
+}
+
+// Resend messages which have not been acknowledged in time.
+func (s *Session) resendLoop() {
+	ticker := time.NewTicker(ResendInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		var resend []*ClientMessage
+		s.lock.Lock()
+		for _, p := range s.unacked {
+			if time.Since(p.sentAt) >= ResendInterval {
+				p.sentAt = time.Now()
+				resend = append(resend, p.message)
+			}
+		}
+		s.lock.Unlock()
+
+		for _, msg := range resend {
+			if err := s.write(msg); err != nil {
+				s.close(err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package manager_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/itsdalmo/ssm-sh/manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtaci/smux"
+)
+
+func TestClientMessage(t *testing.T) {
+	msg := manager.NewClientMessage(manager.InputStreamMessage, 7, manager.PayloadOutput, []byte("ls -la\n"))
+	msg.CreatedDate = time.Unix(1500000000, 123000000)
+
+	b, err := msg.MarshalBinary()
+	assert.Nil(t, err)
+	assert.Equal(t, 120+len(msg.Payload), len(b))
+	assert.Equal(t, "input_stream_data               ", string(b[4:36]))
+
+	actual := &manager.ClientMessage{}
+	assert.Nil(t, actual.UnmarshalBinary(b))
+	assert.Equal(t, msg.MessageType, actual.MessageType)
+	assert.Equal(t, msg.MessageID, actual.MessageID)
+	assert.Equal(t, msg.SequenceNumber, actual.SequenceNumber)
+	assert.Equal(t, msg.PayloadType, actual.PayloadType)
+	assert.Equal(t, msg.Payload, actual.Payload)
+	assert.True(t, msg.CreatedDate.Equal(actual.CreatedDate))
+
+	t.Run("Invalid digest is rejected", func(t *testing.T) {
+		b[len(b)-1] = 'x'
+		assert.NotNil(t, (&manager.ClientMessage{}).UnmarshalBinary(b))
+	})
+
+	t.Run("Short message is rejected", func(t *testing.T) {
+		assert.NotNil(t, (&manager.ClientMessage{}).UnmarshalBinary(b[:100]))
+	})
+}
+
+// agentStandIn plays the part of the SSM agent on the other end of the data channel.
+type agentStandIn struct {
+	t        *testing.T
+	conn     *websocket.Conn
+	received []*manager.ClientMessage
+}
+
+func (a *agentStandIn) send(sequence int64, payloadType uint32, payload string) {
+	msg := manager.NewClientMessage(manager.OutputStreamMessage, sequence, payloadType, []byte(payload))
+	b, err := msg.MarshalBinary()
+	assert.Nil(a.t, err)
+	assert.Nil(a.t, a.conn.WriteMessage(websocket.BinaryMessage, b))
+}
+
+func (a *agentStandIn) receive() *manager.ClientMessage {
+	_, b, err := a.conn.ReadMessage()
+	if !assert.Nil(a.t, err) {
+		return nil
+	}
+	msg := &manager.ClientMessage{}
+	assert.Nil(a.t, msg.UnmarshalBinary(b))
+	a.received = append(a.received, msg)
+	return msg
+}
+
+func (a *agentStandIn) acknowledge(msg *manager.ClientMessage) {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"AcknowledgedMessageType":           msg.MessageType,
+		"AcknowledgedMessageId":             msg.MessageID,
+		"AcknowledgedMessageSequenceNumber": msg.SequenceNumber,
+		"IsSequentialMessage":               true,
+	})
+	ack := manager.NewClientMessage(manager.AcknowledgeMessage, 0, 0, payload)
+	b, _ := ack.MarshalBinary()
+	assert.Nil(a.t, a.conn.WriteMessage(websocket.BinaryMessage, b))
+}
+
+func TestSession(t *testing.T) {
+	manager.ResendInterval = 50 * time.Millisecond
+	agent := &agentStandIn{t: t}
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer conn.Close()
+		agent.conn = conn
+
+		var open map[string]string
+		assert.Nil(t, conn.ReadJSON(&open))
+		assert.Equal(t, "token", open["TokenValue"])
+
+		agent.send(0, manager.PayloadHandshakeRequest, `{"AgentVersion":"2.3.0.0","RequestedClientActions":[{"ActionType":"SessionType","ActionParameters":{"SessionType":"Standard_Stream"}}]}`)
+		agent.send(2, manager.PayloadOutput, "world\n")
+		agent.send(1, manager.PayloadOutput, "hello ")
+		agent.send(1, manager.PayloadOutput, "hello ")
+
+		// Leave the first copy of "exit" unacknowledged so that it is sent again.
+		var exit *manager.ClientMessage
+		for {
+			msg := agent.receive()
+			if msg == nil {
+				return
+			}
+			if msg.MessageType != manager.InputStreamMessage {
+				continue
+			}
+			if string(msg.Payload) != "exit\n" {
+				agent.acknowledge(msg)
+				continue
+			}
+			if exit != nil && exit.MessageID == msg.MessageID {
+				agent.acknowledge(msg)
+				break
+			}
+			exit = msg
+		}
+		payload, _ := json.Marshal(map[string]string{"MessageType": "channel_closed", "Output": "Exiting session"})
+		b, _ := manager.NewClientMessage(manager.ChannelClosedMessage, 3, 0, payload).MarshalBinary()
+		conn.WriteMessage(websocket.BinaryMessage, b)
+	}))
+	defer server.Close()
+
+	mock := &manager.MockSSM{SessionURL: "ws" + strings.TrimPrefix(server.URL, "http")}
+	m := manager.NewTestManager(mock, nil, nil)
+
+	var output bytes.Buffer
+	s, err := m.StartSession("i-00000000000000001", "", nil, &output)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "session-i-00000000000000001", s.ID)
+	assert.Nil(t, s.Resize(80, 24))
+	version, err := s.AgentVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "2.3.0.0", version)
+	_, err = s.Write([]byte("exit\n"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.Wait())
+	assert.Equal(t, "hello world\n", output.String())
+	assert.Nil(t, s.Close())
+	assert.Nil(t, s.Close())
+	assert.Equal(t, []string{"session-i-00000000000000001"}, mock.TerminatedSessions)
+
+	var acknowledged []int64
+	var inputs []string
+	for _, msg := range agent.received {
+		switch msg.MessageType {
+		case manager.AcknowledgeMessage:
+			var ack map[string]interface{}
+			assert.Nil(t, json.Unmarshal(msg.Payload, &ack))
+			acknowledged = append(acknowledged, int64(ack["AcknowledgedMessageSequenceNumber"].(float64)))
+		case manager.InputStreamMessage:
+			inputs = append(inputs, string(msg.Payload))
+			switch msg.PayloadType {
+			case manager.PayloadHandshakeResponse:
+				assert.Equal(t, int64(0), msg.SequenceNumber)
+				assert.Contains(t, string(msg.Payload), `"ActionStatus":1`)
+			case manager.PayloadSize:
+				assert.Equal(t, int64(1), msg.SequenceNumber)
+				assert.JSONEq(t, `{"cols":80,"rows":24}`, string(msg.Payload))
+			}
+		}
+	}
+	assert.Equal(t, []int64{0, 2, 1, 1}, acknowledged)
+	assert.Equal(t, 4, len(inputs))
+	assert.Equal(t, []string{"exit\n", "exit\n"}, inputs[2:])
+
+	t.Run("Write fails after the session has ended", func(t *testing.T) {
+		_, err := s.Write([]byte("ls\n"))
+		assert.Equal(t, manager.ErrSessionClosed, err)
+	})
+}
+
+// agentStream is the agent end of a data channel, used as a stream after the handshake.
+// Unlike agentStandIn it returns errors instead of failing the test, since the stream
+// is still in use while the session is closed.
+type agentStream struct {
+	conn     *websocket.Conn
+	lock     sync.Mutex // Output and acknowledgements are written concurrently.
+	sequence int64
+	reader   *io.PipeReader
+}
+
+func newAgentStream(conn *websocket.Conn) *agentStream {
+	pr, pw := io.Pipe()
+	stream := &agentStream{conn: conn, reader: pr, sequence: 1}
+	go func() {
+		for {
+			_, b, err := conn.ReadMessage()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			msg := &manager.ClientMessage{}
+			if err := msg.UnmarshalBinary(b); err != nil || msg.MessageType != manager.InputStreamMessage {
+				continue
+			}
+			payload, _ := json.Marshal(map[string]interface{}{
+				"AcknowledgedMessageType":           msg.MessageType,
+				"AcknowledgedMessageId":             msg.MessageID,
+				"AcknowledgedMessageSequenceNumber": msg.SequenceNumber,
+				"IsSequentialMessage":               true,
+			})
+			if err := stream.write(manager.NewClientMessage(manager.AcknowledgeMessage, 0, 0, payload)); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if msg.PayloadType == manager.PayloadOutput {
+				pw.Write(msg.Payload)
+			}
+		}
+	}()
+	return stream
+}
+
+func (s *agentStream) write(msg *manager.ClientMessage) error {
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.conn.WriteMessage(websocket.BinaryMessage, b)
+}
+
+func (s *agentStream) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+func (s *agentStream) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	sequence := s.sequence
+	s.sequence++
+	s.lock.Unlock()
+	if err := s.write(manager.NewClientMessage(manager.OutputStreamMessage, sequence, manager.PayloadOutput, p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *agentStream) Close() error {
+	return s.conn.Close()
+}
+
+// sessionStream reads the output of a session from a pipe.
+type sessionStream struct {
+	*manager.Session
+	reader *io.PipeReader
+}
+
+func (s *sessionStream) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+// Start a session against a stand-in agent, which runs handle after the handshake
+// (if it sends one).
+func startTestSession(t *testing.T, handshake bool, handle func(*agentStandIn)) (*sessionStream, func()) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer conn.Close()
+		agent := &agentStandIn{t: t, conn: conn}
+
+		var open map[string]string
+		assert.Nil(t, conn.ReadJSON(&open))
+		if handshake {
+			agent.send(0, manager.PayloadHandshakeRequest, `{"AgentVersion":"3.1.1732.0","RequestedClientActions":[]}`)
+		}
+		handle(agent)
+	}))
+
+	mock := &manager.MockSSM{SessionURL: "ws" + strings.TrimPrefix(server.URL, "http")}
+	m := manager.NewTestManager(mock, nil, nil)
+	pr, pw := io.Pipe()
+	s, err := m.StartSession("i-00000000000000001", "AWS-StartPortForwardingSession", nil, pw)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	go func() {
+		pw.CloseWithError(s.Wait())
+	}()
+	return &sessionStream{Session: s, reader: pr}, func() {
+		s.Close()
+		server.Close()
+	}
+}
+
+func TestSessionMultiplexing(t *testing.T) {
+	// The agent echoes each multiplexed stream.
+	stream, stop := startTestSession(t, true, func(agent *agentStandIn) {
+		mux, err := smux.Server(newAgentStream(agent.conn), smux.DefaultConfig())
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer mux.Close()
+		for {
+			s, err := mux.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer s.Close()
+				io.Copy(s, s)
+			}()
+		}
+	})
+	defer stop()
+
+	version, err := stream.AgentVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "3.1.1732.0", version)
+
+	mux, err := smux.Client(stream, smux.DefaultConfig())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer mux.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := mux.OpenStream()
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer s.Close()
+			message := strings.Repeat(fmt.Sprintf("stream %d\n", i), 500)
+			go s.Write([]byte(message))
+
+			echo := make([]byte, len(message))
+			_, err = io.ReadFull(s, echo)
+			assert.Nil(t, err)
+			assert.Equal(t, message, string(echo))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSessionWithoutHandshake(t *testing.T) {
+	manager.HandshakeTimeout = 100 * time.Millisecond
+	defer func() { manager.HandshakeTimeout = 10 * time.Second }()
+
+	received := make(chan string, 1)
+	stream, stop := startTestSession(t, false, func(agent *agentStandIn) {
+		msg := agent.receive()
+		if msg != nil {
+			agent.acknowledge(msg)
+			received <- string(msg.Payload)
+		}
+	})
+	defer stop()
+
+	version, err := stream.AgentVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "", version)
+	_, err = stream.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", <-received)
+}
@@ -4,8 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/resourcegroups"
+	"github.com/aws/aws-sdk-go/service/resourcegroups/resourcegroupsiface"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/ssm"
@@ -100,12 +105,19 @@ type MockSSM struct {
 	DocumentDescription *ssm.DocumentDescription
 	NextToken           string
 	CommandStatus       string
-	CommandHistory      map[string]*struct {
+	// Status of commands after they are cancelled (Cancelled if not set).
+	CancelStatus   string
+	CommandHistory map[string]*struct {
 		Command *ssm.Command
 		Status  string
 	}
-	Error bool
-	async sync.Mutex
+	SessionURL         string
+	TerminatedSessions []string
+	// Versions of pushed documents (content by version), and their default version.
+	DocumentVersions map[string][]string
+	DefaultVersions  map[string]string
+	Error            bool
+	async            sync.Mutex
 }
 
 func (mock *MockSSM) DescribeInstanceInformation(input *ssm.DescribeInstanceInformationInput) (*ssm.DescribeInstanceInformationOutput, error) {
@@ -113,10 +125,32 @@ func (mock *MockSSM) DescribeInstanceInformation(input *ssm.DescribeInstanceInfo
 		return nil, errors.New("expected")
 	}
 
-	output := mock.Instances
+	// Filter instances on ping status and instance ids.
+	var output []*ssm.InstanceInformation
+	for _, instance := range mock.Instances {
+		keep := true
+		for _, filter := range input.Filters {
+			var value string
+			switch aws.StringValue(filter.Key) {
+			case "PingStatus":
+				value = aws.StringValue(instance.PingStatus)
+			case "InstanceIds":
+				value = aws.StringValue(instance.InstanceId)
+			default:
+				return nil, errors.New("unsupported filter")
+			}
+			if !contains(aws.StringValueSlice(filter.Values), value) {
+				keep = false
+			}
+		}
+		if keep {
+			output = append(output, instance)
+		}
+	}
+
 	if input.MaxResults != nil {
-		if i := int(*input.MaxResults); i < len(mock.Instances) {
-			output = mock.Instances[:i]
+		if i := int(*input.MaxResults); i < len(output) {
+			output = output[:i]
 		}
 	}
 
@@ -202,7 +236,18 @@ func (mock *MockSSM) DescribeDocument(input *ssm.DescribeDocumentInput) (*ssm.De
 		return nil, errors.New("expected")
 	}
 
-	if aws.StringValue(input.Name) != aws.StringValue(mock.DocumentDescription.Name) {
+	// Pushed documents are described from their versions.
+	if versions, ok := mock.DocumentVersions[aws.StringValue(input.Name)]; ok {
+		return &ssm.DescribeDocumentOutput{
+			Document: &ssm.DocumentDescription{
+				Name:           input.Name,
+				LatestVersion:  aws.String(fmt.Sprint(len(versions))),
+				DefaultVersion: aws.String(mock.DefaultVersions[aws.StringValue(input.Name)]),
+			},
+		}, nil
+	}
+
+	if mock.DocumentDescription == nil || aws.StringValue(input.Name) != aws.StringValue(mock.DocumentDescription.Name) {
 		return nil, errors.New("expected")
 	}
 
@@ -211,6 +256,95 @@ func (mock *MockSSM) DescribeDocument(input *ssm.DescribeDocumentInput) (*ssm.De
 	}, nil
 }
 
+func (mock *MockSSM) CreateDocument(input *ssm.CreateDocumentInput) (*ssm.CreateDocumentOutput, error) {
+	if mock.Error {
+		return nil, errors.New("expected")
+	}
+	name := aws.StringValue(input.Name)
+	if _, ok := mock.DocumentVersions[name]; ok {
+		return nil, awserr.New(ssm.ErrCodeDocumentAlreadyExists, "document already exists", nil)
+	}
+	mock.DocumentVersions[name] = []string{aws.StringValue(input.Content)}
+	mock.DefaultVersions[name] = "1"
+	return &ssm.CreateDocumentOutput{
+		DocumentDescription: &ssm.DocumentDescription{
+			Name:            input.Name,
+			DocumentVersion: aws.String("1"),
+		},
+	}, nil
+}
+
+func (mock *MockSSM) UpdateDocument(input *ssm.UpdateDocumentInput) (*ssm.UpdateDocumentOutput, error) {
+	if mock.Error {
+		return nil, errors.New("expected")
+	}
+	name := aws.StringValue(input.Name)
+	versions, ok := mock.DocumentVersions[name]
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeInvalidDocument, "document does not exist", nil)
+	}
+	if versions[len(versions)-1] == aws.StringValue(input.Content) {
+		return nil, awserr.New(ssm.ErrCodeDuplicateDocumentContent, "content is unchanged", nil)
+	}
+	mock.DocumentVersions[name] = append(versions, aws.StringValue(input.Content))
+	return &ssm.UpdateDocumentOutput{
+		DocumentDescription: &ssm.DocumentDescription{
+			Name:            input.Name,
+			DocumentVersion: aws.String(fmt.Sprint(len(versions) + 1)),
+		},
+	}, nil
+}
+
+func (mock *MockSSM) UpdateDocumentDefaultVersion(input *ssm.UpdateDocumentDefaultVersionInput) (*ssm.UpdateDocumentDefaultVersionOutput, error) {
+	if mock.Error {
+		return nil, errors.New("expected")
+	}
+	name := aws.StringValue(input.Name)
+	if _, ok := mock.DocumentVersions[name]; !ok {
+		return nil, awserr.New(ssm.ErrCodeInvalidDocument, "document does not exist", nil)
+	}
+	mock.DefaultVersions[name] = aws.StringValue(input.DocumentVersion)
+	return &ssm.UpdateDocumentDefaultVersionOutput{}, nil
+}
+
+func (mock *MockSSM) GetDocument(input *ssm.GetDocumentInput) (*ssm.GetDocumentOutput, error) {
+	if mock.Error {
+		return nil, errors.New("expected")
+	}
+	name := aws.StringValue(input.Name)
+	versions, ok := mock.DocumentVersions[name]
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeInvalidDocument, "document does not exist", nil)
+	}
+	version := mock.DefaultVersions[name]
+	if input.DocumentVersion != nil {
+		version = aws.StringValue(input.DocumentVersion)
+	}
+	for i, content := range versions {
+		if fmt.Sprint(i+1) == version {
+			return &ssm.GetDocumentOutput{
+				Name:            input.Name,
+				DocumentVersion: aws.String(version),
+				Content:         aws.String(content),
+			}, nil
+		}
+	}
+	return nil, awserr.New(ssm.ErrCodeInvalidDocumentVersion, "version does not exist", nil)
+}
+
+func (mock *MockSSM) DeleteDocument(input *ssm.DeleteDocumentInput) (*ssm.DeleteDocumentOutput, error) {
+	if mock.Error {
+		return nil, errors.New("expected")
+	}
+	name := aws.StringValue(input.Name)
+	if _, ok := mock.DocumentVersions[name]; !ok {
+		return nil, awserr.New(ssm.ErrCodeInvalidDocument, "document does not exist", nil)
+	}
+	delete(mock.DocumentVersions, name)
+	delete(mock.DefaultVersions, name)
+	return &ssm.DeleteDocumentOutput{}, nil
+}
+
 func (mock *MockSSM) SendCommand(input *ssm.SendCommandInput) (*ssm.SendCommandOutput, error) {
 	if mock.Error {
 		return nil, errors.New("expected")
@@ -278,6 +412,9 @@ func (mock *MockSSM) CancelCommand(input *ssm.CancelCommandInput) (*ssm.CancelCo
 		return nil, errors.New("invalid commandId")
 	}
 	cmd.Status = "Cancelled"
+	if mock.CancelStatus != "" {
+		cmd.Status = mock.CancelStatus
+	}
 
 	return &ssm.CancelCommandOutput{}, nil
 }
@@ -312,6 +449,36 @@ func (mock *MockSSM) GetCommandInvocation(input *ssm.GetCommandInvocationInput)
 	}, nil
 }
 
+func (mock *MockSSM) StartSession(input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+	if mock.Error {
+		return nil, errors.New("expected")
+	}
+	return &ssm.StartSessionOutput{
+		SessionId:  aws.String(fmt.Sprintf("session-%s", aws.StringValue(input.Target))),
+		StreamUrl:  aws.String(mock.SessionURL),
+		TokenValue: aws.String("token"),
+	}, nil
+}
+
+func (mock *MockSSM) TerminateSession(input *ssm.TerminateSessionInput) (*ssm.TerminateSessionOutput, error) {
+	if mock.Error {
+		return nil, errors.New("expected")
+	}
+	mock.async.Lock()
+	defer mock.async.Unlock()
+	mock.TerminatedSessions = append(mock.TerminatedSessions, aws.StringValue(input.SessionId))
+	return &ssm.TerminateSessionOutput{SessionId: input.SessionId}, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 type MockS3 struct {
 	s3iface.S3API
 	Error bool
@@ -332,3 +499,54 @@ func (mock *MockS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, er
 		Body: ioutil.NopCloser(strings.NewReader("example s3 output")),
 	}, nil
 }
+
+type MockAutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	Groups map[string][]string
+	Error  bool
+}
+
+func (mock *MockAutoScaling) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	if mock.Error {
+		return nil, errors.New("expected")
+	}
+
+	var groups []*autoscaling.Group
+	for _, name := range aws.StringValueSlice(input.AutoScalingGroupNames) {
+		ids, ok := mock.Groups[name]
+		if !ok {
+			continue
+		}
+		group := &autoscaling.Group{AutoScalingGroupName: aws.String(name)}
+		for _, id := range ids {
+			group.Instances = append(group.Instances, &autoscaling.Instance{InstanceId: aws.String(id)})
+		}
+		groups = append(groups, group)
+	}
+	return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: groups}, nil
+}
+
+type MockResourceGroups struct {
+	resourcegroupsiface.ResourceGroupsAPI
+	Groups map[string][]string
+	Error  bool
+}
+
+func (mock *MockResourceGroups) ListGroupResources(input *resourcegroups.ListGroupResourcesInput) (*resourcegroups.ListGroupResourcesOutput, error) {
+	if mock.Error {
+		return nil, errors.New("expected")
+	}
+
+	arns, ok := mock.Groups[aws.StringValue(input.GroupName)]
+	if !ok {
+		return nil, errors.New("group not found")
+	}
+	var resources []*resourcegroups.ResourceIdentifier
+	for _, arn := range arns {
+		resources = append(resources, &resourcegroups.ResourceIdentifier{
+			ResourceArn:  aws.String(arn),
+			ResourceType: aws.String("AWS::EC2::Instance"),
+		})
+	}
+	return &resourcegroups.ListGroupResourcesOutput{ResourceIdentifiers: resources}, nil
+}
@@ -0,0 +1,58 @@
+package manager
+
+import (
+	"bytes"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// StagedObject is a file which has been uploaded to S3 so that instances can download it.
+type StagedObject struct {
+	Bucket string
+	Key    string
+	URL    string
+}
+
+// StageObject uploads a file to the configured S3 bucket and returns a presigned URL which
+// instances can use to download it (without needing access to the bucket themselves).
+func (m *Manager) StageObject(name string, body []byte, expiry time.Duration) (*StagedObject, error) {
+	if m.s3Bucket == "" {
+		return nil, errors.New("an s3 bucket is required to stage files")
+	}
+	key := path.Join(m.s3KeyPrefix, "ssm-sh", "staged", newUUID(), path.Base(name))
+
+	_, err := m.s3Client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(m.s3Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to upload file to s3")
+	}
+
+	req, _ := m.s3Client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(m.s3Bucket),
+		Key:    aws.String(key),
+	})
+	url, err := req.Presign(expiry)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to presign url")
+	}
+	return &StagedObject{Bucket: m.s3Bucket, Key: key, URL: url}, nil
+}
+
+// RemoveStagedObject deletes a staged file from S3.
+func (m *Manager) RemoveStagedObject(o *StagedObject) error {
+	_, err := m.s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(o.Bucket),
+		Key:    aws.String(o.Key),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to delete staged file from s3")
+	}
+	return nil
+}